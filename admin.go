@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// healthStatus is reported by the health endpoint
+type healthStatus struct {
+	Status   string `json:"status"`
+	Ready    bool   `json:"ready"`
+	Identity string `json:"identity,omitempty"`
+	Leader   string `json:"leader,omitempty"`
+	IsLeader bool   `json:"isLeader"`
+}
+
+// adminMux serves health and metrics endpoints, separate from proxied traffic
+func (ip *IngressProxy) adminMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", ip.handleHealthz)
+	mux.Handle("/metrics", prometheus.Handler())
+	mux.HandleFunc("/shadowed-rules", ip.handleShadowedRules)
+	mux.HandleFunc("/endpoints", ip.handleEndpoints)
+	return mux
+}
+
+func (ip *IngressProxy) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	status := healthStatus{
+		Status:   "ok",
+		Ready:    ip.ready(),
+		IsLeader: ip.isLeader(),
+	}
+	if ip.leaderElector != nil {
+		status.Identity = ip.leaderElector.identity
+		status.Leader = ip.leaderElector.getLeader()
+	}
+
+	writeJSON(w, status)
+}
+
+// handleShadowedRules lists rules overridden by ingresses with higher
+// precedence
+func (ip *IngressProxy) handleShadowedRules(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, ip.getShadowedRules())
+}
+
+// handleEndpoints lists the endpoints of backends balanced across their
+// endpoints and their health
+func (ip *IngressProxy) handleEndpoints(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, ip.endpointsHealth())
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warnf("Error writing JSON response: %s", err)
+	}
+}
+
+func (ip *IngressProxy) serveAdmin() {
+	port := ip.currentConfig().AdminPort
+	log.Infof("Start listening for admin requests on port %d", port)
+	err := http.ListenAndServe(fmt.Sprintf(":%d", port), ip.adminMux())
+	log.Error(err)
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// annotationPrefix is shared by all annotations configuring the proxy per
+// ingress
+const annotationPrefix = "kube-ingress-proxy/"
+
+// Reasons of events about annotations
+const (
+	reasonInvalidAnnotation = "InvalidAnnotation"
+	reasonUnknownAnnotation = "UnknownAnnotation"
+)
+
+// ingressSettings configures how requests routed through an ingress are
+// proxied
+type ingressSettings struct {
+	UpstreamTimeout      time.Duration
+	RewriteTarget        string
+	CORSEnabled          bool
+	CORSAllowOrigin      string
+	CORSAllowMethods     []string
+	CORSAllowHeaders     []string
+	CORSAllowCredentials bool
+	CORSMaxAge           time.Duration
+
+	// PathType is the path type of all paths not listed in PathTypes, see
+	// path_match.go
+	PathType  string
+	PathTypes map[string]string
+
+	// UseRegex makes all paths of the ingress regular expressions, unless
+	// listed in PathTypes. Paths of the regex type are compiled into
+	// pathRegexps when the settings are parsed.
+	UseRegex    bool
+	pathRegexps map[string]*regexp.Regexp
+
+	// UpstreamMode decides how backends are reached, see
+	// endpoints_upstream.go
+	UpstreamMode string
+
+	// LoadBalance is the load balancing algorithm of all backends not listed
+	// in LoadBalanceBackends by 'service:port', see load_balancer.go.
+	// LoadBalanceWeights are the weights of endpoints by pod name or IP.
+	LoadBalance         string
+	LoadBalanceBackends map[string]string
+	LoadBalanceWeights  map[string]int
+
+	// HealthCheck configures active health checks of the endpoints, see
+	// health_check.go. HealthCheckBackends overrides the type of health
+	// check by 'service:port', 'none' turns them off for a backend.
+	HealthCheck         healthCheck
+	HealthCheckBackends map[string]string
+
+	// AuthSecret is the secret with the credentials of basic
+	// authentication, none is needed without it. See auth.go.
+	AuthSecret string
+	AuthRealm  string
+}
+
+// defaultIngressSettings are used for everything not set by annotations
+func defaultIngressSettings(c *Config) *ingressSettings {
+	return &ingressSettings{
+		UpstreamTimeout:  c.UpstreamTimeout,
+		CORSAllowOrigin:  "*",
+		CORSAllowMethods: []string{"GET", "PUT", "POST", "DELETE", "PATCH", "OPTIONS"},
+		CORSAllowHeaders: []string{"DNT", "Keep-Alive", "User-Agent", "X-Requested-With", "If-Modified-Since", "Cache-Control", "Content-Type", "Authorization"},
+		CORSMaxAge:       24 * time.Hour,
+		PathType:         c.DefaultPathType,
+		UpstreamMode:     c.UpstreamMode,
+		LoadBalance:      c.LoadBalance,
+		AuthRealm:        defaultAuthRealm,
+		HealthCheck: healthCheck{
+			Path:               "/",
+			Interval:           10 * time.Second,
+			Timeout:            2 * time.Second,
+			HealthyThreshold:   2,
+			UnhealthyThreshold: 3,
+		},
+	}
+}
+
+// ingressAnnotation parses the value of one annotation into the settings
+type ingressAnnotation struct {
+	name  string
+	parse func(value string, s *ingressSettings) error
+}
+
+var ingressAnnotations = []ingressAnnotation{
+	{"upstream-timeout", durationAnnotation(func(s *ingressSettings) *time.Duration { return &s.UpstreamTimeout })},
+	{"rewrite-target", stringAnnotation(func(s *ingressSettings) *string { return &s.RewriteTarget }, validatePathValue)},
+	{"cors-enable", boolAnnotation(func(s *ingressSettings) *bool { return &s.CORSEnabled })},
+	{"cors-allow-origin", stringAnnotation(func(s *ingressSettings) *string { return &s.CORSAllowOrigin }, nil)},
+	{"cors-allow-methods", listAnnotation(func(s *ingressSettings) *[]string { return &s.CORSAllowMethods })},
+	{"cors-allow-headers", listAnnotation(func(s *ingressSettings) *[]string { return &s.CORSAllowHeaders })},
+	{"cors-allow-credentials", boolAnnotation(func(s *ingressSettings) *bool { return &s.CORSAllowCredentials })},
+	{"cors-max-age", durationAnnotation(func(s *ingressSettings) *time.Duration { return &s.CORSMaxAge })},
+	{"use-regex", boolAnnotation(func(s *ingressSettings) *bool { return &s.UseRegex })},
+	{"path-type", stringAnnotation(func(s *ingressSettings) *string { return &s.PathType }, validatePathType)},
+	{"path-types", pathTypesAnnotation},
+	{"upstream-mode", stringAnnotation(func(s *ingressSettings) *string { return &s.UpstreamMode }, validateUpstreamMode)},
+	{"load-balance", stringAnnotation(func(s *ingressSettings) *string { return &s.LoadBalance }, validateLoadBalance)},
+	{"load-balance-backends", loadBalanceBackendsAnnotation},
+	{"load-balance-weights", loadBalanceWeightsAnnotation},
+	{"health-check", stringAnnotation(func(s *ingressSettings) *string { return &s.HealthCheck.Type }, validateHealthCheckType)},
+	{"health-check-path", stringAnnotation(func(s *ingressSettings) *string { return &s.HealthCheck.Path }, validatePathValue)},
+	{"health-check-interval", durationAnnotation(func(s *ingressSettings) *time.Duration { return &s.HealthCheck.Interval })},
+	{"health-check-timeout", durationAnnotation(func(s *ingressSettings) *time.Duration { return &s.HealthCheck.Timeout })},
+	{"health-check-healthy-threshold", intAnnotation(func(s *ingressSettings) *int { return &s.HealthCheck.HealthyThreshold })},
+	{"health-check-unhealthy-threshold", intAnnotation(func(s *ingressSettings) *int { return &s.HealthCheck.UnhealthyThreshold })},
+	{"health-check-backends", healthCheckBackendsAnnotation},
+	{"auth-secret", stringAnnotation(func(s *ingressSettings) *string { return &s.AuthSecret }, validateSecretName)},
+	{"auth-realm", stringAnnotation(func(s *ingressSettings) *string { return &s.AuthRealm }, validateRealm)},
+}
+
+func stringAnnotation(field func(*ingressSettings) *string, validate func(string) error) func(string, *ingressSettings) error {
+	return func(value string, s *ingressSettings) error {
+		if validate != nil {
+			if err := validate(value); err != nil {
+				return err
+			}
+		}
+		*field(s) = value
+		return nil
+	}
+}
+
+func boolAnnotation(field func(*ingressSettings) *bool) func(string, *ingressSettings) error {
+	return func(value string, s *ingressSettings) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a boolean", value)
+		}
+		*field(s) = b
+		return nil
+	}
+}
+
+func durationAnnotation(field func(*ingressSettings) *time.Duration) func(string, *ingressSettings) error {
+	return func(value string, s *ingressSettings) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a duration", value)
+		}
+		if d <= 0 {
+			return fmt.Errorf("duration '%s' has to be positive", value)
+		}
+		*field(s) = d
+		return nil
+	}
+}
+
+func intAnnotation(field func(*ingressSettings) *int) func(string, *ingressSettings) error {
+	return func(value string, s *ingressSettings) error {
+		i, err := strconv.Atoi(value)
+		if err != nil || i <= 0 {
+			return fmt.Errorf("'%s' is not a positive number", value)
+		}
+		*field(s) = i
+		return nil
+	}
+}
+
+func listAnnotation(field func(*ingressSettings) *[]string) func(string, *ingressSettings) error {
+	return func(value string, s *ingressSettings) error {
+		list := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); len(item) > 0 {
+				list = append(list, item)
+			}
+		}
+		if len(list) == 0 {
+			return fmt.Errorf("list is empty")
+		}
+		*field(s) = list
+		return nil
+	}
+}
+
+// pathTypesAnnotation reads path types of single paths as a list of
+// 'path=type'. Regex paths may contain ',' and '=', so an item only ends
+// at a ',' following '=' and a word, see nextPathType.
+func pathTypesAnnotation(value string, s *ingressSettings) error {
+	pathTypes := make(map[string]string)
+	for rest := value; ; {
+		if rest = strings.TrimLeft(rest, " ,"); len(rest) == 0 {
+			break
+		}
+		path, pathType, next, err := nextPathType(rest)
+		if err != nil {
+			return err
+		}
+		pathTypes[path] = pathType
+		rest = next
+	}
+	if len(pathTypes) == 0 {
+		return fmt.Errorf("list is empty")
+	}
+	s.PathTypes = pathTypes
+	return nil
+}
+
+// nextPathType splits the first 'path=type' off a list of path types. The
+// path ends at the first '=' followed by a word of letters and '-' up to a
+// ',' or the end, all other '=' and ',' are part of the path.
+func nextPathType(value string) (path, pathType, rest string, err error) {
+	for pos := 0; pos < len(value); pos++ {
+		if value[pos] != '=' {
+			continue
+		}
+		pathType, rest = value[pos+1:], ""
+		if end := strings.Index(pathType, ","); end >= 0 {
+			pathType, rest = pathType[:end], pathType[end+1:]
+		}
+		pathType = strings.TrimSpace(pathType)
+		if len(pathType) == 0 || len(strings.Trim(pathType, "abcdefghijklmnopqrstuvwxyz-")) > 0 {
+			continue
+		}
+		if err := validatePathType(pathType); err != nil {
+			return "", "", "", err
+		}
+		return strings.TrimSpace(value[:pos]), pathType, rest, nil
+	}
+	return "", "", "", fmt.Errorf("'%s' is not of the form 'path=type'", strings.TrimSpace(value))
+}
+
+// loadBalanceBackendsAnnotation reads load balancing algorithms of single
+// backends as a list of 'service:port=algorithm'
+func loadBalanceBackendsAnnotation(value string, s *ingressSettings) error {
+	algorithms := make(map[string]string)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) == 0 {
+			continue
+		}
+		pos := strings.Index(item, "=")
+		if pos < 0 || !strings.Contains(item[:pos], ":") {
+			return fmt.Errorf("'%s' is not of the form 'service:port=algorithm'", item)
+		}
+		backend, algorithm := strings.TrimSpace(item[:pos]), strings.TrimSpace(item[pos+1:])
+		if err := validateLoadBalance(algorithm); err != nil {
+			return err
+		}
+		algorithms[backend] = algorithm
+	}
+	if len(algorithms) == 0 {
+		return fmt.Errorf("list is empty")
+	}
+	s.LoadBalanceBackends = algorithms
+	return nil
+}
+
+// healthCheckBackendsAnnotation reads health check types of single backends
+// as a list of 'service:port=type'
+func healthCheckBackendsAnnotation(value string, s *ingressSettings) error {
+	types := make(map[string]string)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) == 0 {
+			continue
+		}
+		pos := strings.Index(item, "=")
+		if pos < 0 || !strings.Contains(item[:pos], ":") {
+			return fmt.Errorf("'%s' is not of the form 'service:port=type'", item)
+		}
+		backend, checkType := strings.TrimSpace(item[:pos]), strings.TrimSpace(item[pos+1:])
+		if checkType != healthCheckNone {
+			if err := validateHealthCheckType(checkType); err != nil {
+				return err
+			}
+		}
+		types[backend] = checkType
+	}
+	if len(types) == 0 {
+		return fmt.Errorf("list is empty")
+	}
+	s.HealthCheckBackends = types
+	return nil
+}
+
+// loadBalanceWeightsAnnotation reads weights of endpoints as a list of
+// 'pod=weight' or 'ip=weight'
+func loadBalanceWeightsAnnotation(value string, s *ingressSettings) error {
+	weights := make(map[string]int)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) == 0 {
+			continue
+		}
+		pos := strings.LastIndex(item, "=")
+		if pos < 0 {
+			return fmt.Errorf("'%s' is not of the form 'pod=weight'", item)
+		}
+		weight, err := strconv.Atoi(strings.TrimSpace(item[pos+1:]))
+		if err != nil || weight <= 0 {
+			return fmt.Errorf("weight of '%s' has to be a positive number", item)
+		}
+		weights[strings.TrimSpace(item[:pos])] = weight
+	}
+	if len(weights) == 0 {
+		return fmt.Errorf("list is empty")
+	}
+	s.LoadBalanceWeights = weights
+	return nil
+}
+
+func validatePathValue(value string) error {
+	if !strings.HasPrefix(value, "/") {
+		return fmt.Errorf("path '%s' does not start with '/'", value)
+	}
+	return nil
+}
+
+// parseIngressSettings reads the settings of an ingress from its annotations.
+// Annotations override the global defaults within the limits set by the
+// operator. Invalid values are returned as problems, which make
+// validateIngress reject the ingress, the returned settings keep the defaults
+// for them.
+func parseIngressSettings(ing *extensions.Ingress, c *Config) (*ingressSettings, []ingressProblem) {
+	settings := *defaultIngressSettings(c)
+	problems := []ingressProblem{}
+
+	disabled := make(map[string]bool)
+	for _, name := range c.DisabledAnnotations {
+		disabled[strings.TrimPrefix(strings.TrimSpace(name), annotationPrefix)] = true
+	}
+
+	for _, annotation := range ingressAnnotations {
+		value, ok := ing.Annotations[annotationPrefix+annotation.name]
+		if !ok {
+			continue
+		}
+
+		candidate := settings
+		err := annotation.parse(value, &candidate)
+		if err == nil && disabled[annotation.name] {
+			err = fmt.Errorf("disabled by the operator")
+		}
+		if err == nil {
+			err = candidate.checkLimits(c)
+		}
+		if err != nil {
+			problems = append(problems, ingressProblem{
+				reasonInvalidAnnotation,
+				fmt.Sprintf("annotation %s%s: %s", annotationPrefix, annotation.name, err),
+			})
+			continue
+		}
+		settings = candidate
+	}
+
+	problems = append(problems, settings.compilePaths(ing)...)
+
+	return &settings, problems
+}
+
+// compilePaths compiles the paths of an ingress with the regex path type as
+// POSIX extended regular expressions, anchored at the start of the request
+// path. A match is as long as possible, like for POSIX. Paths of other types
+// are not compiled, they may contain any character.
+func (s *ingressSettings) compilePaths(ing *extensions.Ingress) []ingressProblem {
+	problems := []ingressProblem{}
+	s.pathRegexps = make(map[string]*regexp.Regexp)
+
+	for _, rule := range ing.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			if s.pathTypeFor(path.Path) != pathTypeRegex {
+				continue
+			}
+			if _, ok := s.pathRegexps[path.Path]; ok {
+				continue
+			}
+			expr := path.Path
+			if len(expr) == 0 {
+				expr = "/"
+			}
+			re, err := regexp.CompilePOSIX("^(" + expr + ")")
+			if err != nil {
+				problems = append(problems, ingressProblem{
+					reasonInvalidPath,
+					fmt.Sprintf("path '%s' of host '%s' is not a valid regular expression: %s", path.Path, rule.Host, err),
+				})
+				continue
+			}
+			s.pathRegexps[path.Path] = re
+		}
+	}
+
+	return problems
+}
+
+// checkLimits makes sure settings stay within the limits set by the operator
+func (s *ingressSettings) checkLimits(c *Config) error {
+	if c.MaxUpstreamTimeout > 0 && s.UpstreamTimeout > c.MaxUpstreamTimeout {
+		return fmt.Errorf("upstream timeout %s exceeds the maximum of %s", s.UpstreamTimeout, c.MaxUpstreamTimeout)
+	}
+	return nil
+}
+
+// unknownAnnotations reports annotations with the project prefix which are
+// not understood by the proxy
+func unknownAnnotations(ing *extensions.Ingress) []ingressProblem {
+	known := make(map[string]bool)
+	for _, annotation := range ingressAnnotations {
+		known[annotationPrefix+annotation.name] = true
+	}
+
+	names := []string{}
+	for name := range ing.Annotations {
+		if strings.HasPrefix(name, annotationPrefix) && !known[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	problems := []ingressProblem{}
+	for _, name := range names {
+		problems = append(problems, ingressProblem{
+			reasonUnknownAnnotation,
+			fmt.Sprintf("annotation %s is not supported", name),
+		})
+	}
+	return problems
+}
+
+// rewritePath replaces the matched path prefix of a request by the rewrite
+// target
+func (s *ingressSettings) rewritePath(r *http.Request, matched string) {
+	if len(s.RewriteTarget) == 0 {
+		return
+	}
+	rest := strings.TrimPrefix(r.URL.Path, matched)
+	if len(rest) == 0 {
+		r.URL.Path = s.RewriteTarget
+		return
+	}
+	r.URL.Path = strings.TrimSuffix(s.RewriteTarget, "/") + "/" + strings.TrimPrefix(rest, "/")
+}
+
+// handleCORS adds CORS headers to the response and answers preflight
+// requests, it returns true if the request has been answered
+func (s *ingressSettings) handleCORS(w http.ResponseWriter, r *http.Request) bool {
+	if !s.CORSEnabled {
+		return false
+	}
+
+	if s.CORSAllowCredentials && s.CORSAllowOrigin == "*" {
+		// browsers refuse credentials for any origin, the origin of the
+		// request is allowed instead
+		w.Header().Add("Vary", "Origin")
+		if origin := r.Header.Get("Origin"); len(origin) > 0 {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", s.CORSAllowOrigin)
+	}
+	if s.CORSAllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if r.Method != "OPTIONS" || len(r.Header.Get("Access-Control-Request-Method")) == 0 {
+		return false
+	}
+
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(s.CORSAllowMethods, ", "))
+	w.Header().Set("Access-Control-Allow-Headers", strings.Join(s.CORSAllowHeaders, ", "))
+	w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(s.CORSMaxAge/time.Second)))
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// settingsForIngress returns the parsed settings of an applied ingress
+func (ip *IngressProxy) settingsForIngress(ing *extensions.Ingress) *ingressSettings {
+	if settings, ok := ip.currentSnapshot().settings[ingressKey(ing)]; ok {
+		return settings
+	}
+	return defaultIngressSettings(ip.currentConfig().Config)
+}
+
+// parseSettings parses the settings of all ingresses used for routing
+func parseSettings(ingresses []*extensions.Ingress, c *Config) map[string]*ingressSettings {
+	settings := make(map[string]*ingressSettings)
+	for _, ing := range ingresses {
+		settings[ingressKey(ing)], _ = parseIngressSettings(ing, c)
+	}
+	return settings
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+func TestParseIngressSettings(t *testing.T) {
+	config := NewConfig()
+	defaults := defaultIngressSettings(config)
+
+	ing := &extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "ingress1",
+			Namespace: "default",
+			Annotations: map[string]string{
+				annotationPrefix + "upstream-timeout":   "5s",
+				annotationPrefix + "rewrite-target":     "/api",
+				annotationPrefix + "cors-enable":        "true",
+				annotationPrefix + "cors-allow-methods": "GET, POST",
+				annotationPrefix + "cors-max-age":       "-1s",
+				annotationPrefix + "rewrite-targets":    "/typo",
+				"other.io/setting":                      "ignored",
+			},
+		},
+	}
+
+	settings, problems := parseIngressSettings(ing, config)
+	if settings.UpstreamTimeout != 5*time.Second {
+		t.Errorf("unexpected upstream timeout %s", settings.UpstreamTimeout)
+	}
+	if settings.RewriteTarget != "/api" || !settings.CORSEnabled {
+		t.Errorf("unexpected settings %+v", settings)
+	}
+	if len(settings.CORSAllowMethods) != 2 || settings.CORSAllowMethods[1] != "POST" {
+		t.Errorf("unexpected CORS methods %v", settings.CORSAllowMethods)
+	}
+	if settings.CORSMaxAge != defaults.CORSMaxAge {
+		t.Errorf("invalid CORS max age should keep the default, got %s", settings.CORSMaxAge)
+	}
+	if len(problems) != 1 || problems[0].Reason != reasonInvalidAnnotation {
+		t.Errorf("expected an invalid annotation, got %v", problems)
+	}
+
+	unknown := unknownAnnotations(ing)
+	if len(unknown) != 1 || unknown[0].Reason != reasonUnknownAnnotation {
+		t.Errorf("expected an unknown annotation, got %v", unknown)
+	}
+}
+
+func TestRewritePath(t *testing.T) {
+	tests := []struct {
+		target, matched, path, expected string
+	}{
+		{"", "/app", "/app/page", "/app/page"},
+		{"/", "/app", "/app/page", "/page"},
+		{"/", "/app", "/app", "/"},
+		{"/api/v1", "/app", "/app/page", "/api/v1/page"},
+		{"/api/", "", "/page", "/api/page"},
+	}
+
+	for _, test := range tests {
+		settings := &ingressSettings{RewriteTarget: test.target}
+		r := &http.Request{URL: &url.URL{Path: test.path}}
+		settings.rewritePath(r, test.matched)
+		if r.URL.Path != test.expected {
+			t.Errorf("rewrite of %s matched by %s to %s: expected %s, got %s", test.path, test.matched, test.target, test.expected, r.URL.Path)
+		}
+	}
+}
+
+func TestHandleCORS(t *testing.T) {
+	settings := defaultIngressSettings(NewConfig())
+	settings.CORSEnabled = true
+
+	r, _ := http.NewRequest("OPTIONS", "http://www.test.de/", nil)
+	r.Header.Set("Access-Control-Request-Method", "POST")
+	w := httptest.NewRecorder()
+	if !settings.handleCORS(w, r) {
+		t.Fatalf("preflight request should be answered")
+	}
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("unexpected preflight response %d %v", w.Code, w.Header())
+	}
+
+	r, _ = http.NewRequest("GET", "http://www.test.de/", nil)
+	w = httptest.NewRecorder()
+	if settings.handleCORS(w, r) {
+		t.Errorf("simple request should be proxied")
+	}
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("simple request is missing CORS headers %v", w.Header())
+	}
+
+	// credentials can't be allowed for any origin, the origin is reflected
+	settings.CORSAllowCredentials = true
+	r, _ = http.NewRequest("GET", "http://www.test.de/", nil)
+	r.Header.Set("Origin", "http://app.test.de")
+	w = httptest.NewRecorder()
+	settings.handleCORS(w, r)
+	if w.Header().Get("Access-Control-Allow-Origin") != "http://app.test.de" || w.Header().Get("Vary") != "Origin" || w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("origin should be reflected with credentials, got %v", w.Header())
+	}
+
+	settings.CORSAllowOrigin = "http://app.test.de"
+	r, _ = http.NewRequest("GET", "http://www.test.de/", nil)
+	r.Header.Set("Origin", "http://other.test.de")
+	w = httptest.NewRecorder()
+	settings.handleCORS(w, r)
+	if w.Header().Get("Access-Control-Allow-Origin") != "http://app.test.de" || len(w.Header().Get("Vary")) > 0 {
+		t.Errorf("configured origin should be sent, got %v", w.Header())
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/util/validation"
+)
+
+// Requests routed through an ingress with the auth-secret annotation need
+// basic authentication. The secret is in the namespace of the ingress and
+// holds the credentials under the keys of a 'kubernetes.io/basic-auth'
+// secret. The credentials are loaded into the snapshot like certificates,
+// requests are checked before they are proxied.
+
+// defaultAuthRealm is the realm sent to clients without auth-realm
+// annotation
+const defaultAuthRealm = "Authentication Required"
+
+func validateRealm(realm string) error {
+	for _, c := range realm {
+		if c < ' ' || c == 0x7f {
+			return fmt.Errorf("realm %q contains control characters", realm)
+		}
+	}
+	return nil
+}
+
+// quoteRealm quotes a realm for the WWW-Authenticate header, only '"' and
+// '\' are escaped in quoted strings of HTTP headers
+func quoteRealm(realm string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(realm) + `"`
+}
+
+func validateSecretName(name string) error {
+	if !validation.IsDNS1123Subdomain(name) {
+		return fmt.Errorf("'%s' is not a valid secret name", name)
+	}
+	return nil
+}
+
+// authSecret is the credentials loaded from a version of an auth secret
+type authSecret struct {
+	resourceVersion string
+	username        []byte
+	password        []byte
+}
+
+// parseAuthSecret reads the credentials of an auth secret
+func parseAuthSecret(secret *api.Secret) (*authSecret, error) {
+	username := secret.Data[api.BasicAuthUsernameKey]
+	password := secret.Data[api.BasicAuthPasswordKey]
+	if len(username) == 0 || len(password) == 0 {
+		return nil, fmt.Errorf("no %s or %s", api.BasicAuthUsernameKey, api.BasicAuthPasswordKey)
+	}
+	return &authSecret{
+		resourceVersion: secret.ResourceVersion,
+		username:        username,
+		password:        password,
+	}, nil
+}
+
+// authSecretName returns the auth secret an ingress refers to, read from
+// its annotation as the settings may be invalid
+func authSecretName(ing *extensions.Ingress) string {
+	return ing.Annotations[annotationPrefix+"auth-secret"]
+}
+
+// loadAuthSecrets loads the credentials of the auth secrets of the
+// ingresses. The credentials of the previous snapshot are kept for a secret
+// which is missing or invalid.
+func (ip *IngressProxy) loadAuthSecrets(s, previous *snapshot) {
+	s.authSecrets = make(map[string]*authSecret)
+	if ip.kubeClient == nil && ip.secretCache == nil {
+		return
+	}
+
+	for _, ing := range s.ingresses {
+		name := s.settings[ingressKey(ing)].AuthSecret
+		key := ing.Namespace + "/" + name
+		if len(name) == 0 {
+			continue
+		}
+		if _, ok := s.authSecrets[key]; ok {
+			continue
+		}
+		if secret, err := ip.loadAuthSecret(ing, name, previous); err == nil {
+			s.authSecrets[key] = secret
+		} else if previous != nil && previous.authSecrets[key] != nil {
+			s.authSecrets[key] = previous.authSecrets[key]
+		}
+	}
+}
+
+// loadAuthSecret reads the credentials of an auth secret, the credentials
+// of the previous snapshot are reused if the secret didn't change. Problems
+// are reported as events.
+func (ip *IngressProxy) loadAuthSecret(ing *extensions.Ingress, secretName string, previous *snapshot) (*authSecret, error) {
+	secret, err := ip.getSecret(ing.Namespace, secretName)
+	if err != nil {
+		ip.recordEvent(ing, api.EventTypeWarning, reasonMissingSecret, "Auth secret '%s/%s' not found: %s", ing.Namespace, secretName, err)
+		return nil, err
+	}
+
+	if previous != nil && len(secret.ResourceVersion) > 0 {
+		if loaded, ok := previous.authSecrets[ing.Namespace+"/"+secretName]; ok && loaded.resourceVersion == secret.ResourceVersion {
+			return loaded, nil
+		}
+	}
+
+	loaded, err := parseAuthSecret(secret)
+	if err != nil {
+		ip.recordEvent(ing, api.EventTypeWarning, reasonInvalidSecret, "Auth secret '%s/%s' is invalid: %s", ing.Namespace, secretName, err)
+		return nil, err
+	}
+	return loaded, nil
+}
+
+// authorize checks the credentials of a request for a backend of an ingress
+// with basic authentication. Requests without valid credentials are
+// answered, the request is only proxied if it returns true.
+func (ip *IngressProxy) authorize(s *snapshot, w http.ResponseWriter, r *http.Request, b *ingressBackend) bool {
+	if len(b.Settings.AuthSecret) == 0 {
+		return true
+	}
+
+	secret, ok := s.authSecrets[b.Namespace+"/"+b.Settings.AuthSecret]
+	if !ok {
+		ip.httpError(w, "Authentication not available", 503)
+		return false
+	}
+
+	username, password, ok := r.BasicAuth()
+	if ok && subtle.ConstantTimeCompare([]byte(username), secret.username) == 1 && subtle.ConstantTimeCompare([]byte(password), secret.password) == 1 {
+		return true
+	}
+
+	w.Header().Set("WWW-Authenticate", "Basic realm="+quoteRealm(b.Settings.AuthRealm))
+	ip.httpError(w, "Unauthorized", 401)
+	return false
+}
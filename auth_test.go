@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+func exampleAuthSecret(name, username, password string) *api.Secret {
+	return &api.Secret{
+		ObjectMeta: api.ObjectMeta{Name: name, Namespace: "default"},
+		Type:       api.SecretTypeBasicAuth,
+		Data: map[string][]byte{
+			api.BasicAuthUsernameKey: []byte(username),
+			api.BasicAuthPasswordKey: []byte(password),
+		},
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	ip := exampleIngress()
+	ip.secretCache = syncedCache(t, "secrets", exampleAuthSecret("users", "admin", "secret"))
+
+	ing := ip.ingresses()[0]
+	ing.Annotations = map[string]string{
+		annotationPrefix + "auth-secret": "users",
+		annotationPrefix + "auth-realm":  "Staff",
+	}
+	ip.SetIngresses(ip.ingresses())
+
+	authorize := func(username, password string) (bool, *httptest.ResponseRecorder) {
+		r := httptest.NewRequest("GET", "http://www.test.de/", nil)
+		if len(username) > 0 {
+			r.SetBasicAuth(username, password)
+		}
+		w := httptest.NewRecorder()
+		s := ip.currentSnapshot()
+		return ip.authorize(s, w, r, s.routeRequestToBackend(r)), w
+	}
+
+	if ok, w := authorize("", ""); ok || w.Code != 401 || w.Header().Get("WWW-Authenticate") != `Basic realm="Staff"` {
+		t.Errorf("request without credentials should be challenged, got %d %v", w.Code, w.Header())
+	}
+	if ok, w := authorize("admin", "wrong"); ok || w.Code != 401 {
+		t.Errorf("request with a wrong password should be rejected, got %d", w.Code)
+	}
+	if ok, _ := authorize("admin", "secret"); !ok {
+		t.Errorf("request with valid credentials should be proxied")
+	}
+
+	// the credentials are kept while the secret is missing
+	ip.secretCache = syncedCache(t, "secrets")
+	ip.SetIngresses(ip.ingresses())
+	if ok, _ := authorize("admin", "secret"); !ok {
+		t.Errorf("previous credentials not kept")
+	}
+
+	// the challenge is also sent through handle
+	w := httptest.NewRecorder()
+	ip.handle(w, httptest.NewRequest("GET", "http://www.test.de/", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("unexpected response %d %v", w.Code, w.Header())
+	}
+
+	// requests are not proxied without credentials to check against
+	ip = exampleIngress()
+	ip.secretCache = syncedCache(t, "secrets")
+	ip.ingresses()[0].Annotations = ing.Annotations
+	ip.SetIngresses(ip.ingresses())
+	if ok, w := authorize("admin", "secret"); ok || w.Code != 503 {
+		t.Errorf("request should not be proxied without auth secret, got %d", w.Code)
+	}
+}
+
+func TestValidateAuthSecret(t *testing.T) {
+	ip := exampleIngress()
+	ing := ip.ingresses()[0]
+	ing.Annotations = map[string]string{annotationPrefix + "auth-secret": "users"}
+	if problems := ip.validateIngress(ing); len(problems) != 1 || problems[0].Reason != reasonMissingSecret {
+		t.Errorf("auth secret should be rejected without API server, got %v", problems)
+	}
+
+	ing.Annotations[annotationPrefix+"auth-secret"] = "Invalid_Name"
+	if _, problems := parseIngressSettings(ing, NewConfig()); len(problems) != 1 || problems[0].Reason != reasonInvalidAnnotation {
+		t.Errorf("expected an invalid annotation for an invalid secret name, got %v", problems)
+	}
+
+	if _, err := parseAuthSecret(exampleAuthSecret("users", "admin", "")); err == nil {
+		t.Errorf("expected an error for a secret without password")
+	}
+	if secret, err := parseAuthSecret(exampleAuthSecret("users", "admin", "secret")); err != nil || string(secret.username) != "admin" {
+		t.Errorf("unexpected credentials %v, %v", secret, err)
+	}
+}
+
+func TestAuthRealm(t *testing.T) {
+	for realm, expected := range map[string]string{
+		"Staff":               `"Staff"`,
+		`Staff "Berlin"`:      `"Staff \"Berlin\""`,
+		`C:\Staff`:            `"C:\\Staff"`,
+		"Mitarbeiter München": `"Mitarbeiter München"`,
+	} {
+		if quoted := quoteRealm(realm); quoted != expected {
+			t.Errorf("realm %s quoted as %s, expected %s", realm, quoted, expected)
+		}
+	}
+
+	ing := &extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "ingress1",
+			Namespace: "default",
+			Annotations: map[string]string{
+				annotationPrefix + "auth-secret": "users",
+				annotationPrefix + "auth-realm":  "Staff\r\nSet-Cookie: x=1",
+			},
+		},
+	}
+	if _, problems := parseIngressSettings(ing, NewConfig()); len(problems) != 1 || problems[0].Reason != reasonInvalidAnnotation {
+		t.Errorf("expected an invalid annotation for a realm with control characters, got %v", problems)
+	}
+}
+
+func TestAuthSecretReferences(t *testing.T) {
+	ing := &extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{
+			Name:        "ingress1",
+			Namespace:   "default",
+			Annotations: map[string]string{annotationPrefix + "auth-secret": "users"},
+		},
+	}
+	r := objectReferences{}
+	r.addIngress(ing)
+	if !r[referenceKey("secrets", "default", "users")] {
+		t.Errorf("changes of the auth secret should rebuild the routing, references %v", r)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+// Backend proxies are built per snapshot, one per namespace, service, port,
+// upstream timeout and upstream mode, and when balancing across endpoints
+// per load balancing algorithm, endpoint weights and health check. A proxy
+// is taken over by the next snapshot if its target and transport settings
+// did not change, proxies balancing across endpoints get the current
+// endpoints. Proxies no longer used are stale, their idle connections are
+// closed once the requests still using them are finished.
+
+// backendProxy proxies requests to a backend with its own transport
+type backendProxy struct {
+	*httputil.ReverseProxy
+	key         string
+	target      string
+	dialTimeout time.Duration
+	transport   *http.Transport
+	endpoints   *endpointPool
+	health      *healthChecker
+	healthStart sync.Once
+
+	lock     sync.Mutex
+	inFlight int
+	stale    bool
+}
+
+// backendProxyKey identifies the proxy of a backend
+func backendProxyKey(b *ingressBackend) string {
+	key := fmt.Sprintf("%s/%s/%s@%s,%s", b.Namespace, b.ServiceName, b.ServicePort.String(), b.Settings.UpstreamTimeout, b.Settings.UpstreamMode)
+	if b.Settings.UpstreamMode == upstreamModeEndpoints {
+		key += fmt.Sprintf(",%s[%s]", b.Settings.loadBalanceFor(b.IngressBackend), b.Settings.loadBalanceWeightsKey())
+		if check := b.Settings.healthCheckFor(b.IngressBackend); len(check.Type) > 0 {
+			key += "," + check.key()
+		}
+	}
+	return key
+}
+
+// newBackendProxy creates the proxy for a backend
+func (ip *IngressProxy) newBackendProxy(c *runtimeConfig, b *ingressBackend) (*backendProxy, error) {
+	target, err := ip.urlFromBackend(c, b)
+	if err != nil {
+		return nil, err
+	}
+	transport := newTransport(c.Config, b.Settings.UpstreamTimeout)
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = transport
+
+	p := &backendProxy{
+		ReverseProxy: proxy,
+		key:          backendProxyKey(b),
+		target:       target.String(),
+		dialTimeout:  c.DialTimeout,
+		transport:    transport,
+	}
+
+	if c.balanceEndpoints(b) {
+		balancer, err := newBalancer(b.Settings.loadBalanceFor(b.IngressBackend))
+		if err != nil {
+			return nil, err
+		}
+		p.endpoints = newEndpointPool(balancer)
+		proxy.Transport = &endpointsTransport{pool: p.endpoints, transport: transport}
+		if check := b.Settings.healthCheckFor(b.IngressBackend); len(check.Type) > 0 {
+			p.health = newHealthChecker(check, p.endpoints, b, p.key)
+		}
+	}
+	return p, nil
+}
+
+// balanceEndpoints checks if requests to a backend are balanced across its
+// endpoints, overridden backends are always dialed directly
+func (c *runtimeConfig) balanceEndpoints(b *ingressBackend) bool {
+	if _, ok := c.backendOverride(b); ok {
+		return false
+	}
+	return b.Settings.UpstreamMode == upstreamModeEndpoints
+}
+
+// reusable checks if a proxy of a previous snapshot can be used for a
+// backend
+func (p *backendProxy) reusable(ip *IngressProxy, c *runtimeConfig, b *ingressBackend) bool {
+	target, err := ip.urlFromBackend(c, b)
+	return err == nil && p.target == target.String() && p.dialTimeout == c.DialTimeout &&
+		(p.endpoints != nil) == c.balanceEndpoints(b)
+}
+
+func (p *backendProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.lock.Lock()
+	p.inFlight++
+	p.lock.Unlock()
+
+	defer p.done()
+	p.ReverseProxy.ServeHTTP(w, r)
+}
+
+func (p *backendProxy) done() {
+	p.lock.Lock()
+	p.inFlight--
+	drained := p.stale && p.inFlight == 0
+	p.lock.Unlock()
+
+	if drained {
+		p.transport.CloseIdleConnections()
+	}
+}
+
+// close marks a proxy as stale, its connections are closed once idle
+func (p *backendProxy) close() {
+	p.lock.Lock()
+	p.stale = true
+	drained := p.inFlight == 0
+	p.lock.Unlock()
+
+	log.Debugf("Closing stale backend proxy %s", p.key)
+	if p.health != nil {
+		p.health.close()
+	}
+	if drained {
+		p.transport.CloseIdleConnections()
+	}
+}
+
+// buildBackendProxies sets up the proxies for all routes of a snapshot,
+// taking over the unchanged proxies of the previous snapshot. Routes to
+// backends which can't be resolved are left without proxy and reported,
+// proxies balancing across endpoints are updated with the current ones and
+// start their health checks.
+func (ip *IngressProxy) buildBackendProxies(s, previous *snapshot) {
+	s.proxies = make(map[string]*backendProxy)
+	for _, r := range s.routes.all {
+		backend := r.ingressBackend("")
+		key := backendProxyKey(backend)
+
+		proxy, ok := s.proxies[key]
+		if !ok {
+			if old, found := previous.proxy(key); found && old.reusable(ip, s.config, backend) {
+				proxy = old
+			} else {
+				var err error
+				if proxy, err = ip.newBackendProxy(s.config, backend); err != nil {
+					ip.recordEvent(r.Ingress, api.EventTypeWarning, reasonUnknownPort, "%s", err)
+					continue
+				}
+			}
+			if proxy.endpoints != nil {
+				addresses, err := ip.endpointAddresses(backend.Namespace, backend.IngressBackend)
+				if err != nil {
+					ip.recordEvent(r.Ingress, api.EventTypeWarning, reasonNoEndpoints, "%s", err)
+				}
+				proxy.endpoints.set(addresses, backend.Settings.LoadBalanceWeights)
+			}
+			if proxy.health != nil {
+				proxy.healthStart.Do(func() { go proxy.health.run() })
+			}
+			s.proxies[key] = proxy
+		}
+		r.Proxy = proxy
+	}
+}
+
+// closeStaleBackendProxies closes the proxies of the previous snapshot not
+// taken over by the current one
+func closeStaleBackendProxies(s, previous *snapshot) {
+	if previous == nil {
+		return
+	}
+	for key, proxy := range previous.proxies {
+		if s.proxies[key] != proxy {
+			proxy.close()
+		}
+	}
+}
+
+// proxy returns a backend proxy of a snapshot
+func (s *snapshot) proxy(key string) (*backendProxy, bool) {
+	if s == nil {
+		return nil, false
+	}
+	proxy, ok := s.proxies[key]
+	return proxy, ok
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+func TestBackendProxyKeys(t *testing.T) {
+	ip := exampleIngress()
+	other := &extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{Name: "ingress2", Namespace: "team-a"},
+		Spec: extensions.IngressSpec{
+			Rules: []extensions.IngressRule{exampleRule("www.team-a.de", "service2")},
+		},
+	}
+	ip.SetIngresses(append(ip.ingresses(), other))
+
+	route := func(host string) *ingressBackend {
+		r := &http.Request{Host: host, URL: &url.URL{Path: "/"}}
+		return ip.routeRequestToBackend(r)
+	}
+
+	first := route("www.test.de")
+	if first.ServiceName != "service2" || first.Namespace != "default" {
+		t.Fatalf("unexpected backend %+v", first)
+	}
+	if second := route("www.team-a.de"); second.Proxy == first.Proxy {
+		t.Errorf("services with the same name in different namespaces share proxy %s", first.Proxy.key)
+	}
+
+	// unchanged backends keep their proxy
+	ip.SetIngresses(append([]*extensions.Ingress{}, ip.ingresses()...))
+	if b := route("www.test.de"); b.Proxy != first.Proxy {
+		t.Errorf("proxy %s not reused", first.Proxy.key)
+	}
+
+	// changed transport settings need a new proxy
+	ingresses := append([]*extensions.Ingress{}, ip.ingresses()...)
+	config := NewConfig()
+	config.DialTimeout = time.Second
+	if err := ip.reloadConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	ip.SetIngresses(ingresses)
+	if b := route("www.test.de"); b.Proxy == first.Proxy {
+		t.Errorf("proxy %s reused after changing the dial timeout", first.Proxy.key)
+	}
+	if !first.Proxy.stale {
+		t.Errorf("replaced proxy %s not marked stale", first.Proxy.key)
+	}
+}
+
+func TestBackendProxyDrain(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+	}))
+	var lock sync.Mutex
+	closed := 0
+	backend.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			lock.Lock()
+			closed++
+			lock.Unlock()
+		}
+	}
+	backend.Start()
+	defer backend.Close()
+	backendURL, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedConns := func() int {
+		lock.Lock()
+		defer lock.Unlock()
+		return closed
+	}
+
+	ip := NewIngressProxy()
+	reconfigure(t, ip, func(c *Config) {
+		c.BackendOverrides = []string{"service1:8080=" + backendURL.Host}
+	})
+	ip.SetIngresses([]*extensions.Ingress{{
+		ObjectMeta: api.ObjectMeta{Name: "ingress1", Namespace: "default"},
+		Spec: extensions.IngressSpec{
+			Rules: []extensions.IngressRule{exampleRule("www.test.de", "service1")},
+		},
+	}})
+
+	proxy := ip.routeRequestToBackend(httptest.NewRequest("GET", "http://www.test.de/slow", nil)).Proxy
+	inFlight := func() int {
+		proxy.lock.Lock()
+		defer proxy.lock.Unlock()
+		return proxy.inFlight
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w := httptest.NewRecorder()
+		ip.handle(w, httptest.NewRequest("GET", "http://www.test.de/slow", nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("unexpected status %d", w.Code)
+		}
+	}()
+
+	// the backend is removed while the request is in flight
+	for inFlight() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	ip.SetIngresses(nil)
+
+	time.Sleep(50 * time.Millisecond)
+	if n := closedConns(); n != 0 {
+		t.Errorf("%d connections closed while a request was in flight", n)
+	}
+
+	close(release)
+	<-done
+	for timeout := time.Now().Add(5 * time.Second); closedConns() == 0; {
+		if time.Now().After(timeout) {
+			t.Fatal("idle connection of stale proxy not closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/fields"
+	"k8s.io/kubernetes/pkg/labels"
+)
+
+// Config holds all settings of the proxy. The effective config is built from
+// defaults, the config file, environment variables and command line flags,
+// each of them overriding the ones before. Global defaults in the watched
+// ConfigMap override all of them.
+type Config struct {
+	ConfigFile string `yaml:"-"`
+	ConfigMap  string `yaml:"configMap"`
+
+	IngressName          string   `yaml:"ingressName"`
+	Namespaces           []string `yaml:"namespaces"`
+	ExcludedNamespaces   []string `yaml:"excludedNamespaces"`
+	NamespaceSelector    string   `yaml:"namespaceSelector"`
+	IngressClass         string   `yaml:"ingressClass"`
+	IngressClassRequired bool     `yaml:"ingressClassRequired"`
+	IngressSelector      string   `yaml:"ingressSelector"`
+
+	IngressFile         string        `yaml:"ingressFile"`
+	IngressFileInterval time.Duration `yaml:"ingressFileInterval"`
+	BackendOverrides    []string      `yaml:"backendOverrides"`
+
+	PublishAddresses []string `yaml:"publishAddresses"`
+	PublishService   string   `yaml:"publishService"`
+
+	LeaderElect              bool          `yaml:"leaderElect"`
+	LeaderElectResource      string        `yaml:"leaderElectResource"`
+	LeaderElectNamespace     string        `yaml:"leaderElectNamespace"`
+	LeaderElectName          string        `yaml:"leaderElectName"`
+	LeaderElectLeaseDuration time.Duration `yaml:"leaderElectLeaseDuration"`
+	LeaderElectRenewDeadline time.Duration `yaml:"leaderElectRenewDeadline"`
+	LeaderElectRetryPeriod   time.Duration `yaml:"leaderElectRetryPeriod"`
+
+	HttpPort      int    `yaml:"httpPort"`
+	HttpsPort     int    `yaml:"httpsPort"`
+	AdminPort     int    `yaml:"adminPort"`
+	ClusterDomain string `yaml:"clusterDomain"`
+
+	ReadTimeout     time.Duration `yaml:"readTimeout"`
+	WriteTimeout    time.Duration `yaml:"writeTimeout"`
+	DialTimeout     time.Duration `yaml:"dialTimeout"`
+	UpstreamTimeout time.Duration `yaml:"upstreamTimeout"`
+	MaxHeaderBytes  int           `yaml:"maxHeaderBytes"`
+
+	MaxUpstreamTimeout  time.Duration `yaml:"maxUpstreamTimeout"`
+	DisabledAnnotations []string      `yaml:"disabledAnnotations"`
+	DefaultPathType     string        `yaml:"defaultPathType"`
+	UpstreamMode        string        `yaml:"upstreamMode"`
+	LoadBalance         string        `yaml:"loadBalance"`
+
+	LogLevel  string `yaml:"logLevel"`
+	LogFormat string `yaml:"logFormat"`
+
+	TLSMinVersion   string   `yaml:"tlsMinVersion"`
+	TLSCipherSuites []string `yaml:"tlsCipherSuites"`
+
+	Kube KubeClientConfig `yaml:"kube"`
+}
+
+// configEnv maps environment variables to the flags they override. Later
+// entries win if several variables for the same flag are set.
+var configEnv = [][2]string{
+	{"CONFIGMAP", "configmap"},
+	{"INGRESS_NAME", "ingress-name"},
+	{"INGRESS_NAMESPACE", "namespaces"},
+	{"INGRESS_NAMESPACES", "namespaces"},
+	{"EXCLUDE_NAMESPACES", "exclude-namespaces"},
+	{"NAMESPACE_SELECTOR", "namespace-selector"},
+	{"INGRESS_CLASS", "ingress-class"},
+	{"INGRESS_CLASS_REQUIRED", "ingress-class-required"},
+	{"INGRESS_SELECTOR", "ingress-selector"},
+	{"INGRESS_FILE", "ingress-file"},
+	{"INGRESS_FILE_INTERVAL", "ingress-file-interval"},
+	{"BACKEND_OVERRIDES", "backend-override"},
+	{"PUBLISH_ADDRESSES", "publish-address"},
+	{"PUBLISH_SERVICE", "publish-service"},
+	{"LEADER_ELECT", "leader-elect"},
+	{"LEADER_ELECT_RESOURCE", "leader-elect-resource"},
+	{"POD_NAMESPACE", "leader-elect-namespace"},
+	{"LEADER_ELECT_NAMESPACE", "leader-elect-namespace"},
+	{"LEADER_ELECT_NAME", "leader-elect-name"},
+	{"LEADER_ELECT_LEASE_DURATION", "leader-elect-lease-duration"},
+	{"LEADER_ELECT_RENEW_DEADLINE", "leader-elect-renew-deadline"},
+	{"LEADER_ELECT_RETRY_PERIOD", "leader-elect-retry-period"},
+	{"HTTP_PORT", "http-port"},
+	{"HTTPS_PORT", "https-port"},
+	{"ADMIN_PORT", "admin-port"},
+	{"CLUSTER_DOMAIN", "cluster-domain"},
+	{"READ_TIMEOUT", "read-timeout"},
+	{"WRITE_TIMEOUT", "write-timeout"},
+	{"DIAL_TIMEOUT", "dial-timeout"},
+	{"UPSTREAM_TIMEOUT", "upstream-timeout"},
+	{"MAX_HEADER_BYTES", "max-header-bytes"},
+	{"MAX_UPSTREAM_TIMEOUT", "max-upstream-timeout"},
+	{"DISABLED_ANNOTATIONS", "disabled-annotations"},
+	{"DEFAULT_PATH_TYPE", "default-path-type"},
+	{"UPSTREAM_MODE", "upstream-mode"},
+	{"LOAD_BALANCE", "load-balance"},
+	{"LOG_LEVEL", "log-level"},
+	{"LOG_FORMAT", "log-format"},
+	{"TLS_MIN_VERSION", "tls-min-version"},
+	{"TLS_CIPHER_SUITES", "tls-cipher-suites"},
+	{"KUBE_CONTEXT", "context"},
+	{"KUBE_MASTER", "master"},
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+}
+
+var tlsCipherSuites = map[string]uint16{
+	"TLS_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":   tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384": tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+}
+
+func NewConfig() *Config {
+	return &Config{
+		Namespaces:          []string{api.NamespaceDefault},
+		IngressFileInterval: 2 * time.Second,
+		HttpPort:            8080,
+		HttpsPort:           8443,
+		AdminPort:           10254,
+		ClusterDomain:       "cluster.local",
+		ReadTimeout:         60 * time.Second,
+		WriteTimeout:        60 * time.Second,
+		DialTimeout:         30 * time.Second,
+		UpstreamTimeout:     60 * time.Second,
+		MaxHeaderBytes:      http.DefaultMaxHeaderBytes,
+		DefaultPathType:     pathTypeStringPrefix,
+		UpstreamMode:        upstreamModeService,
+		LoadBalance:         loadBalanceRoundRobin,
+		LogLevel:            "info",
+		LogFormat:           "text",
+		TLSMinVersion:       "1.0",
+
+		LeaderElectResource:      "endpoints",
+		LeaderElectNamespace:     api.NamespaceDefault,
+		LeaderElectName:          appName + "-leader",
+		LeaderElectLeaseDuration: 15 * time.Second,
+		LeaderElectRenewDeadline: 10 * time.Second,
+		LeaderElectRetryPeriod:   2 * time.Second,
+	}
+}
+
+// flagSet registers all settings as flags, using the current values as
+// defaults
+func (c *Config) flagSet() *pflag.FlagSet {
+	fs := pflag.NewFlagSet(appName, pflag.ExitOnError)
+
+	fs.StringVar(&c.ConfigFile, "config", c.ConfigFile, "Path to a YAML config file, also read from env var CONFIG_FILE")
+	fs.StringVar(&c.ConfigMap, "configmap", c.ConfigMap, "ConfigMap with global defaults as 'namespace/name', watched for changes and overriding all other sources")
+
+	fs.StringVar(&c.IngressName, "ingress-name", c.IngressName, "Only serve the ingress with this name")
+	fs.StringSliceVar(&c.Namespaces, "namespaces", c.Namespaces, "Namespaces to serve ingresses from, '*' selects all namespaces")
+	fs.StringSliceVar(&c.ExcludedNamespaces, "exclude-namespaces", c.ExcludedNamespaces, "Namespaces never to serve ingresses from")
+	fs.StringVar(&c.NamespaceSelector, "namespace-selector", c.NamespaceSelector, "Only serve ingresses from namespaces matching this label selector")
+	fs.StringVar(&c.IngressClass, "ingress-class", c.IngressClass, "Only serve ingresses annotated with this class")
+	fs.BoolVar(&c.IngressClassRequired, "ingress-class-required", c.IngressClassRequired, "Ignore ingresses without class annotation")
+	fs.StringVar(&c.IngressSelector, "ingress-selector", c.IngressSelector, "Only serve ingresses matching this label selector")
+
+	fs.StringVar(&c.IngressFile, "ingress-file", c.IngressFile, "Read ingresses from a manifest file or directory instead of the API server")
+	fs.DurationVar(&c.IngressFileInterval, "ingress-file-interval", c.IngressFileInterval, "Interval to check ingress files for changes")
+	fs.StringSliceVar(&c.BackendOverrides, "backend-override", c.BackendOverrides, "Send traffic for a backend to another address, as '[namespace/]service:port=host:port'")
+
+	fs.StringSliceVar(&c.PublishAddresses, "publish-address", c.PublishAddresses, "IPs or hostnames to publish in the status of served ingresses")
+	fs.StringVar(&c.PublishService, "publish-service", c.PublishService, "Publish the addresses of this service in the status of served ingresses, as 'namespace/name'")
+
+	fs.BoolVar(&c.LeaderElect, "leader-elect", c.LeaderElect, "Elect a leader among the replicas to do cluster-mutating work")
+	fs.StringVar(&c.LeaderElectResource, "leader-elect-resource", c.LeaderElectResource, "Kind of object holding the leader lock (endpoints, configmaps)")
+	fs.StringVar(&c.LeaderElectNamespace, "leader-elect-namespace", c.LeaderElectNamespace, "Namespace of the leader lock object, defaults to env var POD_NAMESPACE")
+	fs.StringVar(&c.LeaderElectName, "leader-elect-name", c.LeaderElectName, "Name of the leader lock object")
+	fs.DurationVar(&c.LeaderElectLeaseDuration, "leader-elect-lease-duration", c.LeaderElectLeaseDuration, "Duration other replicas wait before taking over leadership")
+	fs.DurationVar(&c.LeaderElectRenewDeadline, "leader-elect-renew-deadline", c.LeaderElectRenewDeadline, "Duration the leader retries renewing its lease before stepping down")
+	fs.DurationVar(&c.LeaderElectRetryPeriod, "leader-elect-retry-period", c.LeaderElectRetryPeriod, "Duration between leader election attempts")
+
+	fs.IntVar(&c.HttpPort, "http-port", c.HttpPort, "Port to listen on for HTTP")
+	fs.IntVar(&c.HttpsPort, "https-port", c.HttpsPort, "Port to listen on for HTTPS")
+	fs.IntVar(&c.AdminPort, "admin-port", c.AdminPort, "Port to serve health and metrics endpoints on")
+	fs.StringVar(&c.ClusterDomain, "cluster-domain", c.ClusterDomain, "DNS domain of the cluster")
+
+	fs.DurationVar(&c.ReadTimeout, "read-timeout", c.ReadTimeout, "Maximum duration for reading a client request")
+	fs.DurationVar(&c.WriteTimeout, "write-timeout", c.WriteTimeout, "Maximum duration for writing a response to the client")
+	fs.DurationVar(&c.DialTimeout, "dial-timeout", c.DialTimeout, "Maximum duration for connecting to a backend")
+	fs.DurationVar(&c.UpstreamTimeout, "upstream-timeout", c.UpstreamTimeout, "Maximum duration to wait for backend response headers")
+	fs.IntVar(&c.MaxHeaderBytes, "max-header-bytes", c.MaxHeaderBytes, "Maximum size of client request headers")
+
+	fs.DurationVar(&c.MaxUpstreamTimeout, "max-upstream-timeout", c.MaxUpstreamTimeout, "Maximum upstream timeout ingress annotations may set, unlimited if 0")
+	fs.StringSliceVar(&c.DisabledAnnotations, "disabled-annotations", c.DisabledAnnotations, "Ingress annotations which may not be used, without prefix")
+	fs.StringVar(&c.DefaultPathType, "default-path-type", c.DefaultPathType, "Path type of ingress paths without path type annotation (exact, prefix, string-prefix, regex)")
+	fs.StringVar(&c.UpstreamMode, "upstream-mode", c.UpstreamMode, "How backends are reached without upstream mode annotation, through the service or balanced across its endpoints (service, endpoints)")
+	fs.StringVar(&c.LoadBalance, "load-balance", c.LoadBalance, "Load balancing algorithm across endpoints without load balance annotation (round-robin, weighted-round-robin, least-outstanding, p2c-ewma, random, consistent-hash:header:<name>, consistent-hash:cookie:<name>, consistent-hash:ip)")
+
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "Log level (debug, info, warning, error)")
+	fs.StringVar(&c.LogFormat, "log-format", c.LogFormat, "Log format (text, json)")
+
+	fs.StringVar(&c.TLSMinVersion, "tls-min-version", c.TLSMinVersion, "Minimum TLS version (1.0, 1.1, 1.2)")
+	fs.StringSliceVar(&c.TLSCipherSuites, "tls-cipher-suites", c.TLSCipherSuites, "Allowed TLS cipher suites, Go defaults are used if empty")
+
+	c.Kube.AddFlags(fs)
+
+	return fs
+}
+
+// LoadConfig builds the effective config from all sources
+func LoadConfig(args []string) (*Config, error) {
+	// the first pass over the flags only looks for the config file
+	c := NewConfig()
+	if err := c.flagSet().Parse(args); err != nil {
+		return nil, err
+	}
+	configFile := c.ConfigFile
+	if len(configFile) == 0 {
+		configFile = os.Getenv("CONFIG_FILE")
+	}
+
+	c = NewConfig()
+	c.ConfigFile = configFile
+	if len(configFile) > 0 {
+		if err := c.readFile(configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.readEnv(); err != nil {
+		return nil, err
+	}
+
+	if err := c.flagSet().Parse(args); err != nil {
+		return nil, err
+	}
+
+	return c, c.validate()
+}
+
+func (c *Config) readFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	// yaml appends to the lists of the defaults, they are emptied first and
+	// restored if the file doesn't set them
+	defaults := reflect.ValueOf(*c)
+	fields := reflect.ValueOf(c).Elem()
+	for i := 0; i < fields.NumField(); i++ {
+		if field := fields.Field(i); field.Kind() == reflect.Slice {
+			field.Set(reflect.Zero(field.Type()))
+		}
+	}
+
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return fmt.Errorf("Error parsing config file '%s': %s", path, err)
+	}
+
+	for i := 0; i < fields.NumField(); i++ {
+		if field := fields.Field(i); field.Kind() == reflect.Slice && field.IsNil() {
+			field.Set(defaults.Field(i))
+		}
+	}
+	return nil
+}
+
+func (c *Config) readEnv() error {
+	for _, env := range configEnv {
+		value := os.Getenv(env[0])
+		if len(value) == 0 {
+			continue
+		}
+		// use a fresh flag set, so lists are replaced instead of appended to
+		if err := c.flagSet().Set(env[1], value); err != nil {
+			return fmt.Errorf("Invalid value in env var %s: %s", env[0], err)
+		}
+	}
+	return nil
+}
+
+func (c *Config) validate() error {
+	if len(c.namespaces()) == 0 {
+		return fmt.Errorf("Please provide a list of namespaces")
+	}
+
+	for _, port := range []int{c.HttpPort, c.HttpsPort, c.AdminPort} {
+		if port < 0 || port > 65535 {
+			return fmt.Errorf("Invalid port %d", port)
+		}
+	}
+
+	if len(c.ConfigMap) > 0 {
+		if _, _, err := parseConfigMapName(c.ConfigMap); err != nil {
+			return err
+		}
+	}
+
+	if c.IngressFileInterval <= 0 {
+		return fmt.Errorf("Invalid ingress file interval %s", c.IngressFileInterval)
+	}
+
+	if c.MaxHeaderBytes <= 0 || c.MaxHeaderBytes > maxHeaderBytesLimit {
+		return fmt.Errorf("Invalid maximum header size %d, has to be between 1 and %d", c.MaxHeaderBytes, maxHeaderBytesLimit)
+	}
+
+	if c.MaxUpstreamTimeout > 0 && c.UpstreamTimeout > c.MaxUpstreamTimeout {
+		return fmt.Errorf("Upstream timeout %s exceeds the maximum of %s", c.UpstreamTimeout, c.MaxUpstreamTimeout)
+	}
+
+	if err := validatePathType(c.DefaultPathType); err != nil {
+		return fmt.Errorf("Invalid default path type: %s", err)
+	}
+
+	if err := validateUpstreamMode(c.UpstreamMode); err != nil {
+		return fmt.Errorf("Invalid upstream mode: %s", err)
+	}
+
+	if err := validateLoadBalance(c.LoadBalance); err != nil {
+		return fmt.Errorf("Invalid load balancing algorithm: %s", err)
+	}
+
+	for namespace := range c.excludedNamespaces() {
+		if _, err := fields.ParseSelector("metadata.namespace!=" + namespace); err != nil {
+			return fmt.Errorf("Invalid excluded namespace '%s': %s", namespace, err)
+		}
+	}
+
+	if _, err := labels.Parse(c.NamespaceSelector); err != nil {
+		return fmt.Errorf("Invalid namespace selector '%s': %s", c.NamespaceSelector, err)
+	}
+
+	if _, err := labels.Parse(c.IngressSelector); err != nil {
+		return fmt.Errorf("Invalid label selector '%s': %s", c.IngressSelector, err)
+	}
+
+	if _, err := parseBackendOverrides(c.BackendOverrides); err != nil {
+		return err
+	}
+
+	if len(c.PublishService) > 0 {
+		if _, _, err := parsePublishService(c.PublishService); err != nil {
+			return err
+		}
+	}
+
+	if c.LeaderElect {
+		if c.LeaderElectResource != "endpoints" && c.LeaderElectResource != "configmaps" {
+			return fmt.Errorf("Invalid leader election resource '%s'", c.LeaderElectResource)
+		}
+		if c.LeaderElectRenewDeadline >= c.LeaderElectLeaseDuration {
+			return fmt.Errorf("Leader election renew deadline has to be shorter than the lease duration")
+		}
+		if c.LeaderElectRetryPeriod >= c.LeaderElectRenewDeadline {
+			return fmt.Errorf("Leader election retry period has to be shorter than the renew deadline")
+		}
+	}
+
+	if _, err := log.ParseLevel(c.LogLevel); err != nil {
+		return err
+	}
+
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		return fmt.Errorf("Invalid log format '%s'", c.LogFormat)
+	}
+
+	_, err := c.tlsConfig()
+	return err
+}
+
+// namespaces returns the namespaces to watch without the excluded ones,
+// api.NamespaceAll stands for the whole cluster
+func (c *Config) namespaces() []string {
+	excluded := c.excludedNamespaces()
+	namespaces := []string{}
+	for _, namespace := range c.Namespaces {
+		namespace = strings.TrimSpace(namespace)
+		if namespace == "*" {
+			return []string{api.NamespaceAll}
+		}
+		if len(namespace) > 0 && !excluded[namespace] {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces
+}
+
+func (c *Config) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{}
+
+	version, ok := tlsVersions[c.TLSMinVersion]
+	if !ok {
+		return nil, fmt.Errorf("Invalid TLS version '%s'", c.TLSMinVersion)
+	}
+	config.MinVersion = version
+
+	for _, name := range c.TLSCipherSuites {
+		suite, ok := tlsCipherSuites[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown TLS cipher suite '%s'", name)
+		}
+		config.CipherSuites = append(config.CipherSuites, suite)
+	}
+
+	return config, nil
+}
+
+func (c *Config) String() string {
+	out, err := yaml.Marshal(c)
+	if err != nil {
+		return err.Error()
+	}
+	return string(out)
+}
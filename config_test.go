@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const exampleConfigFile = `
+namespaces: [file1, file2]
+excludedNamespaces: [kube-system, kube-public]
+publishAddresses: [10.0.0.1, 10.0.0.2]
+httpPort: 81
+adminPort: 9000
+upstreamTimeout: 10s
+logLevel: debug
+`
+
+func writeConfigFile(t *testing.T) string {
+	f, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(exampleConfigFile); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func setEnv(t *testing.T, env map[string]string) func() {
+	for name, value := range env {
+		if err := os.Setenv(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return func() {
+		for name := range env {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t)
+	defer os.Remove(path)
+
+	c, err := LoadConfig([]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c, NewConfig()) {
+		t.Errorf("expected the defaults without any source, got %+v", c)
+	}
+
+	// the file overrides the defaults and replaces their lists
+	c, err = LoadConfig([]string{"--config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.HttpPort != 81 || c.AdminPort != 9000 || c.UpstreamTimeout != 10*time.Second || c.LogLevel != "debug" {
+		t.Errorf("expected the settings of the file, got %+v", c)
+	}
+	if c.HttpsPort != 8443 || c.LogFormat != "text" {
+		t.Errorf("expected the defaults for settings missing in the file, got %+v", c)
+	}
+	if expected := []string{"file1", "file2"}; !reflect.DeepEqual(c.Namespaces, expected) {
+		t.Errorf("expected namespaces %v of the file, got %v", expected, c.Namespaces)
+	}
+
+	// env vars override the file and replace its lists, the file is also
+	// found through the env
+	defer setEnv(t, map[string]string{
+		"CONFIG_FILE":        path,
+		"HTTP_PORT":          "82",
+		"UPSTREAM_TIMEOUT":   "20s",
+		"INGRESS_NAMESPACES": "env1",
+		"EXCLUDE_NAMESPACES": "env2",
+	})()
+	c, err = LoadConfig([]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.HttpPort != 82 || c.UpstreamTimeout != 20*time.Second {
+		t.Errorf("expected the settings of the env, got %+v", c)
+	}
+	if c.AdminPort != 9000 || c.LogLevel != "debug" {
+		t.Errorf("expected the file for settings missing in the env, got %+v", c)
+	}
+	if expected := []string{"env1"}; !reflect.DeepEqual(c.Namespaces, expected) {
+		t.Errorf("expected namespaces %v of the env, got %v", expected, c.Namespaces)
+	}
+	if expected := []string{"env2"}; !reflect.DeepEqual(c.ExcludedNamespaces, expected) {
+		t.Errorf("expected excluded namespaces %v of the env, got %v", expected, c.ExcludedNamespaces)
+	}
+
+	// flags override everything and replace the lists of all other sources
+	c, err = LoadConfig([]string{
+		"--http-port=83",
+		"--namespaces=flag1,flag2",
+		"--publish-address=10.0.0.3",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.HttpPort != 83 || c.UpstreamTimeout != 20*time.Second || c.AdminPort != 9000 {
+		t.Errorf("expected the flags on top of the env and the file, got %+v", c)
+	}
+	if expected := []string{"flag1", "flag2"}; !reflect.DeepEqual(c.Namespaces, expected) {
+		t.Errorf("expected namespaces %v of the flags, got %v", expected, c.Namespaces)
+	}
+	if expected := []string{"10.0.0.3"}; !reflect.DeepEqual(c.PublishAddresses, expected) {
+		t.Errorf("expected publish addresses %v of the flags, got %v", expected, c.PublishAddresses)
+	}
+	if expected := []string{"env2"}; !reflect.DeepEqual(c.ExcludedNamespaces, expected) {
+		t.Errorf("expected excluded namespaces %v of the env, got %v", expected, c.ExcludedNamespaces)
+	}
+}
+
+func TestLoadConfigInvalidEnv(t *testing.T) {
+	defer setEnv(t, map[string]string{"HTTP_PORT": "http"})()
+	if _, err := LoadConfig([]string{}); err == nil {
+		t.Errorf("expected an error for an invalid env var")
+	}
+}
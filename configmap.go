@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/fields"
+	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+// configMapKeys are the settings the global ConfigMap may change, named like
+// their flags
+var configMapKeys = []string{
+	"upstream-timeout",
+	"dial-timeout",
+	"read-timeout",
+	"write-timeout",
+	"max-header-bytes",
+	"max-upstream-timeout",
+	"disabled-annotations",
+	"default-path-type",
+	"upstream-mode",
+	"load-balance",
+	"log-level",
+	"log-format",
+	"tls-min-version",
+	"tls-cipher-suites",
+}
+
+func parseConfigMapName(configMap string) (string, string, error) {
+	parts := strings.Split(configMap, "/")
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return "", "", fmt.Errorf("Invalid ConfigMap '%s', expected 'namespace/name'", configMap)
+	}
+	return parts[0], parts[1], nil
+}
+
+// withConfigMap returns a copy of the config with the settings of the
+// ConfigMap applied on top
+func (c *Config) withConfigMap(data map[string]string) (*Config, error) {
+	copied := *c
+	fs := copied.flagSet()
+
+	keys := make(map[string]bool)
+	for _, key := range configMapKeys {
+		keys[key] = true
+	}
+
+	names := make([]string, 0, len(data))
+	for name := range data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !keys[name] {
+			log.Warnf("Ignoring unknown key '%s' in ConfigMap %s", name, c.ConfigMap)
+			continue
+		}
+		if err := fs.Set(name, strings.TrimSpace(data[name])); err != nil {
+			return nil, fmt.Errorf("Invalid value for key '%s' in ConfigMap %s: %s", name, c.ConfigMap, err)
+		}
+	}
+
+	return &copied, copied.validate()
+}
+
+// configMapWatcher follows the ConfigMap with global defaults and reloads the
+// config whenever it changes
+type configMapWatcher struct {
+	ip        *IngressProxy
+	namespace string
+	name      string
+	cache     *resourceCache
+	data      map[string]string
+}
+
+func newConfigMapWatcher(ip *IngressProxy, configMap string) (*configMapWatcher, error) {
+	namespace, name, err := parseConfigMapName(configMap)
+	if err != nil {
+		return nil, err
+	}
+	w := &configMapWatcher{
+		ip:        ip,
+		namespace: namespace,
+		name:      name,
+	}
+	client := ip.kubeClient.ConfigMaps(namespace)
+	w.cache = newResourceCache("configmaps", []string{namespace},
+		clientSource(
+			func(namespace string, opts api.ListOptions) (runtime.Object, error) {
+				return client.List(opts)
+			},
+			func(namespace string, opts api.ListOptions) (watch.Interface, error) {
+				return client.Watch(opts)
+			},
+			func(namespace string, opts *api.ListOptions) error {
+				opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", name)
+				return nil
+			},
+		),
+		resourceHandler{
+			replaced: w.listed,
+			changed:  w.changed,
+		},
+	)
+	return w, nil
+}
+
+func (w *configMapWatcher) String() string {
+	return fmt.Sprintf("ConfigMap %s/%s", w.namespace, w.name)
+}
+
+// listed applies the current state of the ConfigMap, a missing ConfigMap
+// leaves the defaults in place
+func (w *configMapWatcher) listed(namespace string, objs []runtime.Object) {
+	if len(objs) == 0 {
+		log.Warnf("%s not found, using defaults", w)
+		w.apply(nil)
+		return
+	}
+	w.apply(objs[0].(*api.ConfigMap).Data)
+}
+
+// changed applies a change of the ConfigMap seen by the watch
+func (w *configMapWatcher) changed(eventType watch.EventType, obj runtime.Object) {
+	switch eventType {
+	case watch.Added, watch.Modified:
+		w.apply(obj.(*api.ConfigMap).Data)
+	case watch.Deleted:
+		log.Warnf("%s has been deleted, using defaults", w)
+		w.apply(nil)
+	}
+}
+
+// apply reloads the config with the data of the ConfigMap, invalid data is
+// rejected and the current config keeps being used
+func (w *configMapWatcher) apply(data map[string]string) {
+	reload := w.data != nil
+	if reload && reflect.DeepEqual(w.data, data) {
+		return
+	}
+
+	c, err := w.ip.baseConfig.withConfigMap(data)
+	if err != nil {
+		log.Errorf("Not applying %s: %s", w, err)
+		configMapErrorsMetric.Inc()
+		return
+	}
+
+	if data == nil {
+		data = map[string]string{}
+	}
+	w.data = data
+
+	log.Infof("Applying %s", w)
+	if err := w.ip.reloadConfig(c); err != nil {
+		log.Errorf("Not applying %s: %s", w, err)
+		configMapErrorsMetric.Inc()
+	}
+}
+
+// reloadConfig applies a changed config while the proxy is running
+func (ip *IngressProxy) reloadConfig(c *Config) error {
+	if err := ip.swapConfig(c); err != nil {
+		return err
+	}
+	c.setupLogging()
+
+	// backend proxies and ingress settings depend on the config, they are
+	// rebuilt with a new snapshot. Settings of applied ingresses may no
+	// longer be valid, they are checked again.
+	ip.ingressStoreLock.Lock()
+	ip.applyIngressStore()
+	for _, ing := range ip.ingressStore {
+		ip.queueIngressCheck(ing)
+	}
+	ip.ingressStoreLock.Unlock()
+
+	return nil
+}
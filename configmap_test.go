@@ -0,0 +1,119 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+func TestConfigWithConfigMap(t *testing.T) {
+	base := NewConfig()
+	base.ConfigMap = "kube-system/ingress-proxy"
+
+	c, err := base.withConfigMap(map[string]string{
+		"upstream-timeout":     "10s",
+		"max-upstream-timeout": "30s",
+		"tls-cipher-suites":    "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+		"log-format":           "json",
+		"http-port":            "80",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if c.UpstreamTimeout != 10*time.Second || c.MaxUpstreamTimeout != 30*time.Second {
+		t.Errorf("unexpected timeouts %s/%s", c.UpstreamTimeout, c.MaxUpstreamTimeout)
+	}
+	if len(c.TLSCipherSuites) != 2 || c.LogFormat != "json" {
+		t.Errorf("unexpected config %+v", c)
+	}
+	if c.HttpPort != base.HttpPort {
+		t.Errorf("ports can't be changed through the ConfigMap, got %d", c.HttpPort)
+	}
+	if base.UpstreamTimeout != 60*time.Second || base.LogFormat != "text" {
+		t.Errorf("the base config must not be changed")
+	}
+
+	if _, err := base.withConfigMap(map[string]string{"log-format": "xml"}); err == nil {
+		t.Errorf("expected an error for an invalid log format")
+	}
+	if _, err := base.withConfigMap(map[string]string{"max-upstream-timeout": "5s"}); err == nil {
+		t.Errorf("expected an error for a default timeout above the maximum")
+	}
+}
+
+func TestAnnotationLimits(t *testing.T) {
+	c := NewConfig()
+	c.UpstreamTimeout = 10 * time.Second
+	c.MaxUpstreamTimeout = 30 * time.Second
+	c.DisabledAnnotations = []string{"cors-enable"}
+
+	ing := &extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "ingress1",
+			Namespace: "default",
+			Annotations: map[string]string{
+				annotationPrefix + "upstream-timeout": "1m",
+				annotationPrefix + "cors-enable":      "true",
+				annotationPrefix + "rewrite-target":   "/",
+			},
+		},
+	}
+
+	settings, problems := parseIngressSettings(ing, c)
+	if settings.UpstreamTimeout != c.UpstreamTimeout {
+		t.Errorf("upstream timeout above the limit should keep the default, got %s", settings.UpstreamTimeout)
+	}
+	if settings.CORSEnabled {
+		t.Errorf("disabled annotation should not be applied")
+	}
+	if settings.RewriteTarget != "/" {
+		t.Errorf("allowed annotation should be applied")
+	}
+	if len(problems) != 2 {
+		t.Errorf("expected two problems, got %v", problems)
+	}
+}
+
+func TestReloadChecksAppliedIngresses(t *testing.T) {
+	ip := exampleIngress()
+	ip.eventRecorder = newEventRecorder(nil)
+
+	copied, err := api.Scheme.DeepCopy(ip.ingresses()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ing := copied.(*extensions.Ingress)
+	ing.ResourceVersion = "2"
+	ing.Annotations = map[string]string{annotationPrefix + "upstream-timeout": "1m"}
+	ip.storeIngress(ing)
+
+	runChecks := func() []*api.Event {
+		for ip.checkQueue.Len() > 0 {
+			item, _ := ip.checkQueue.Get()
+			ip.checkIngress(item.(string))
+			ip.checkQueue.Done(item)
+		}
+		events := []*api.Event{}
+		for len(ip.eventRecorder.queue) > 0 {
+			events = append(events, <-ip.eventRecorder.queue)
+		}
+		return events
+	}
+	if events := runChecks(); len(events) != 1 || events[0].Reason != reasonSync {
+		t.Fatalf("expected a sync event for the applied ingress, got %v", events)
+	}
+
+	reconfigure(t, ip, func(c *Config) {
+		c.UpstreamTimeout = 10 * time.Second
+		c.MaxUpstreamTimeout = 30 * time.Second
+	})
+	events := runChecks()
+	if len(events) != 1 || events[0].Type != api.EventTypeWarning || events[0].Reason != reasonInvalidAnnotation {
+		t.Fatalf("expected a warning for the upstream timeout above the new maximum, got %v", events)
+	}
+	if settings := ip.settingsForIngress(ing); settings.UpstreamTimeout != 10*time.Second {
+		t.Errorf("expected the default upstream timeout, got %s", settings.UpstreamTimeout)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Upstream modes decide how backends are reached. In the service mode
+// requests are sent to the cluster DNS name of the service and balanced by
+// kube-proxy. In the endpoints mode the proxy balances requests across the
+// ready endpoints of the service itself with one of the algorithms in
+// load_balancer.go, a request is retried on another endpoint if connecting
+// fails. Endpoints are followed through the endpoints cache, a new snapshot
+// updates the endpoints of the existing proxies.
+const (
+	upstreamModeService   = "service"
+	upstreamModeEndpoints = "endpoints"
+)
+
+var upstreamModes = map[string]bool{
+	upstreamModeService:   true,
+	upstreamModeEndpoints: true,
+}
+
+func validateUpstreamMode(value string) error {
+	if !upstreamModes[value] {
+		return fmt.Errorf("unknown upstream mode '%s'", value)
+	}
+	return nil
+}
+
+// endpoint is a ready endpoint of a backend with the state balancers need
+type endpoint struct {
+	// outstanding is first to be aligned for atomic access
+	outstanding int64
+
+	address string
+	weight  int
+
+	lock    sync.Mutex
+	latency time.Duration
+
+	// currentWeight is only used by the weighted round robin balancer,
+	// under its lock
+	currentWeight int
+
+	// unhealthy is set by the health checker, which alone uses the counts
+	// of checks in a row
+	unhealthy      int32
+	checkSuccesses int
+	checkFailures  int
+}
+
+// ewmaWeight is the weight of a new latency sample in the moving average,
+// failed requests count with the latency of errorPenalty
+const (
+	ewmaWeight   = 0.3
+	errorPenalty = time.Second
+)
+
+func (e *endpoint) healthy() bool {
+	return atomic.LoadInt32(&e.unhealthy) == 0
+}
+
+func (e *endpoint) inFlight() int64 {
+	return atomic.LoadInt64(&e.outstanding)
+}
+
+// observe adds the latency of a request to the moving average
+func (e *endpoint) observe(latency time.Duration) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.latency == 0 {
+		e.latency = latency
+		return
+	}
+	e.latency += time.Duration(ewmaWeight * float64(latency-e.latency))
+}
+
+// cost estimates the latency of another request, endpoints without
+// requests yet are the cheapest
+func (e *endpoint) cost() float64 {
+	e.lock.Lock()
+	latency := e.latency
+	e.lock.Unlock()
+	return float64(latency) * float64(e.inFlight()+1)
+}
+
+// endpointAddress is the address of a ready endpoint and the pod behind it
+type endpointAddress struct {
+	Address string
+	IP      string
+	Pod     string
+}
+
+// endpointWeight returns the weight of an endpoint, set by pod name or IP
+func endpointWeight(a endpointAddress, weights map[string]int) int {
+	if weight, ok := weights[a.Pod]; ok && len(a.Pod) > 0 {
+		return weight
+	}
+	if weight, ok := weights[a.IP]; ok {
+		return weight
+	}
+	return 1
+}
+
+// endpointPool holds the ready endpoints of a backend and the balancer
+// picking among them. Requests go to the available endpoints, which are the
+// healthy ones or all if none is healthy.
+type endpointPool struct {
+	endpoints atomic.Value
+	available atomic.Value
+	balancer  balancer
+
+	// lock serializes updates of the endpoints and their health
+	lock sync.Mutex
+}
+
+func newEndpointPool(b balancer) *endpointPool {
+	p := &endpointPool{balancer: b}
+	p.endpoints.Store([]*endpoint{})
+	p.available.Store([]*endpoint{})
+	return p
+}
+
+// set replaces the endpoints, endpoints which stay keep their state
+func (p *endpointPool) set(addresses []endpointAddress, weights map[string]int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	previous := make(map[string]*endpoint)
+	for _, e := range p.get() {
+		previous[e.address] = e
+	}
+
+	endpoints := make([]*endpoint, 0, len(addresses))
+	for _, a := range addresses {
+		weight := endpointWeight(a, weights)
+		if e, ok := previous[a.Address]; ok && e.weight == weight {
+			endpoints = append(endpoints, e)
+			continue
+		}
+		endpoints = append(endpoints, &endpoint{address: a.Address, weight: weight})
+	}
+	p.endpoints.Store(endpoints)
+	p.updateAvailable()
+}
+
+// refresh updates the available endpoints after their health changed
+func (p *endpointPool) refresh() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.updateAvailable()
+}
+
+func (p *endpointPool) updateAvailable() {
+	endpoints := p.get()
+	healthy := make([]*endpoint, 0, len(endpoints))
+	for _, e := range endpoints {
+		if e.healthy() {
+			healthy = append(healthy, e)
+		}
+	}
+	if len(healthy) == 0 {
+		healthy = endpoints
+	}
+	p.available.Store(healthy)
+}
+
+func (p *endpointPool) get() []*endpoint {
+	return p.endpoints.Load().([]*endpoint)
+}
+
+func (p *endpointPool) getAvailable() []*endpoint {
+	return p.available.Load().([]*endpoint)
+}
+
+// endpointsTransport sends requests to the endpoints of a pool
+type endpointsTransport struct {
+	pool      *endpointPool
+	transport *http.Transport
+}
+
+func (t *endpointsTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	candidates := t.pool.getAvailable()
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no ready endpoints")
+	}
+
+	for {
+		e := t.pool.balancer.pick(r, candidates)
+
+		outreq := *r
+		u := *r.URL
+		u.Host = e.address
+		outreq.URL = &u
+
+		atomic.AddInt64(&e.outstanding, 1)
+		start := time.Now()
+		resp, err := t.transport.RoundTrip(&outreq)
+		if err == nil {
+			e.observe(time.Since(start))
+			return trackResponse(e, resp), nil
+		}
+		e.observe(errorPenalty)
+		atomic.AddInt64(&e.outstanding, -1)
+
+		if !retryable(r, err) || len(candidates) == 1 {
+			return nil, err
+		}
+		candidates = withoutEndpoint(candidates, e)
+	}
+}
+
+func withoutEndpoint(endpoints []*endpoint, e *endpoint) []*endpoint {
+	remaining := make([]*endpoint, 0, len(endpoints)-1)
+	for _, other := range endpoints {
+		if other != e {
+			remaining = append(remaining, other)
+		}
+	}
+	return remaining
+}
+
+// trackResponse keeps a request outstanding until its response body is
+// closed. Upgraded connections are not tracked, their body has to stay
+// writable.
+func trackResponse(e *endpoint, resp *http.Response) *http.Response {
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		atomic.AddInt64(&e.outstanding, -1)
+		return resp
+	}
+	resp.Body = &endpointBody{ReadCloser: resp.Body, endpoint: e}
+	return resp
+}
+
+type endpointBody struct {
+	io.ReadCloser
+	endpoint *endpoint
+	once     sync.Once
+}
+
+func (b *endpointBody) Close() error {
+	b.once.Do(func() {
+		atomic.AddInt64(&b.endpoint.outstanding, -1)
+	})
+	return b.ReadCloser.Close()
+}
+
+// retryable decides if a failed request can be sent to another endpoint,
+// which is the case if the connection could not be established and there is
+// no body which might have been consumed
+func retryable(r *http.Request, err error) bool {
+	if r.Body != nil && r.Body != http.NoBody {
+		return false
+	}
+	opErr, ok := err.(*net.OpError)
+	return ok && opErr.Op == "dial"
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/util/intstr"
+)
+
+// exampleEndpoints returns the endpoints of the web service with one subset
+// per address, the pods are named web-1, web-2 and so on
+func exampleEndpoints(t *testing.T, addresses ...string) *api.Endpoints {
+	endpoints := &api.Endpoints{
+		ObjectMeta: api.ObjectMeta{Name: "web", Namespace: "default"},
+	}
+	for i, address := range addresses {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		portNumber, err := strconv.Atoi(port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		endpoints.Subsets = append(endpoints.Subsets, api.EndpointSubset{
+			Addresses: []api.EndpointAddress{{
+				IP:        host,
+				TargetRef: &api.ObjectReference{Kind: "Pod", Namespace: "default", Name: fmt.Sprintf("web-%d", i+1)},
+			}},
+			Ports: []api.EndpointPort{{Name: "http", Port: portNumber}},
+		})
+	}
+	return endpoints
+}
+
+// exampleEndpointsIngress routes www.test.de to the endpoints of the web
+// service
+func exampleEndpointsIngress() *extensions.Ingress {
+	rule := exampleRule("www.test.de", "web")
+	rule.HTTP.Paths[0].Backend.ServicePort = intstr.FromString("http")
+	return &extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{
+			Name:        "ingress1",
+			Namespace:   "default",
+			Annotations: map[string]string{annotationPrefix + "upstream-mode": upstreamModeEndpoints},
+		},
+		Spec: extensions.IngressSpec{
+			Rules: []extensions.IngressRule{rule},
+		},
+	}
+}
+
+func namedBackend(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, name)
+	}))
+}
+
+// backendHits sends requests through the proxy and counts the responses per
+// backend
+func backendHits(ip *IngressProxy, requests int) map[string]int {
+	hits := make(map[string]int)
+	for i := 0; i < requests; i++ {
+		w := httptest.NewRecorder()
+		ip.handle(w, httptest.NewRequest("GET", "http://www.test.de/", nil))
+		if w.Code != http.StatusOK {
+			hits[strconv.Itoa(w.Code)]++
+			continue
+		}
+		hits[w.Body.String()]++
+	}
+	return hits
+}
+
+func TestEndpointsUpstream(t *testing.T) {
+	backend1 := namedBackend("backend1")
+	defer backend1.Close()
+	backend2 := namedBackend("backend2")
+	defer backend2.Close()
+
+	ip := exampleServicePorts(t)
+	ip.endpointsCache = syncedCache(t, "endpoints", exampleEndpoints(t, backend1.Listener.Addr().String(), backend2.Listener.Addr().String()))
+	ip.SetIngresses([]*extensions.Ingress{exampleEndpointsIngress()})
+
+	if hits := backendHits(ip, 10); hits["backend1"] != 5 || hits["backend2"] != 5 {
+		t.Errorf("requests not balanced across endpoints: %v", hits)
+	}
+
+	// pods going away update the endpoints of the existing proxy
+	proxy := ip.routeRequestToBackend(httptest.NewRequest("GET", "http://www.test.de/", nil)).Proxy
+	ip.endpointsCache = syncedCache(t, "endpoints", exampleEndpoints(t, backend2.Listener.Addr().String()))
+	ip.SetIngresses(ip.ingresses())
+	if b := ip.routeRequestToBackend(httptest.NewRequest("GET", "http://www.test.de/", nil)); b.Proxy != proxy {
+		t.Errorf("proxy %s not reused after the endpoints changed", proxy.key)
+	}
+	if hits := backendHits(ip, 4); hits["backend2"] != 4 {
+		t.Errorf("requests not sent to the remaining endpoint: %v", hits)
+	}
+
+	// without ready endpoints requests fail
+	ip.endpointsCache = syncedCache(t, "endpoints", exampleEndpoints(t))
+	ip.SetIngresses(ip.ingresses())
+	if hits := backendHits(ip, 1); hits["502"] != 1 {
+		t.Errorf("expected a bad gateway without endpoints, got %v", hits)
+	}
+}
+
+func TestEndpointsUpstreamRetries(t *testing.T) {
+	backend := namedBackend("backend")
+	defer backend.Close()
+
+	// an endpoint nothing listens on
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := listener.Addr().String()
+	listener.Close()
+
+	ip := exampleServicePorts(t)
+	ip.endpointsCache = syncedCache(t, "endpoints", exampleEndpoints(t, dead, backend.Listener.Addr().String()))
+	ip.SetIngresses([]*extensions.Ingress{exampleEndpointsIngress()})
+
+	if hits := backendHits(ip, 4); hits["backend"] != 4 {
+		t.Errorf("requests not retried on the next endpoint: %v", hits)
+	}
+
+	// requests with a body are not retried
+	failed := 0
+	for i := 0; i < 4; i++ {
+		w := httptest.NewRecorder()
+		ip.handle(w, httptest.NewRequest("POST", "http://www.test.de/", strings.NewReader("body")))
+		if w.Code == http.StatusBadGateway {
+			failed++
+		}
+	}
+	if failed != 2 {
+		t.Errorf("expected 2 of 4 requests with a body to fail, got %d", failed)
+	}
+
+	// without API server endpoints can't be followed
+	if problems := NewIngressProxy().validateIngress(exampleEndpointsIngress()); len(problems) != 1 || problems[0].Reason != reasonNoEndpoints {
+		t.Errorf("expected missing endpoints, got %v", problems)
+	}
+}
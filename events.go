@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	kube "k8s.io/kubernetes/pkg/client/unversioned"
+)
+
+const (
+	// eventRepeatInterval is the minimum time between two identical events
+	eventRepeatInterval = 10 * time.Minute
+	eventQueueSize      = 100
+)
+
+// recordedEvent remembers an event sent to the API server
+type recordedEvent struct {
+	event      *api.Event
+	lastSent   time.Time
+	suppressed int
+}
+
+// eventRecorder writes events against ingresses. Identical events are sent
+// at most once per eventRepeatInterval, repetitions in between are counted
+// and added to the existing event once the interval has passed.
+type eventRecorder struct {
+	client kube.EventNamespacer
+	source api.EventSource
+	queue  chan *api.Event
+
+	lock     sync.Mutex
+	recorded map[string]*recordedEvent
+}
+
+func newEventRecorder(client kube.EventNamespacer) *eventRecorder {
+	return &eventRecorder{
+		client: client,
+		source: api.EventSource{
+			Component: appName,
+			Host:      leaderElectionIdentity(),
+		},
+		queue:    make(chan *api.Event, eventQueueSize),
+		recorded: make(map[string]*recordedEvent),
+	}
+}
+
+func ingressReference(ing *extensions.Ingress) api.ObjectReference {
+	return api.ObjectReference{
+		Kind:            "Ingress",
+		APIVersion:      "extensions/v1beta1",
+		Namespace:       ing.Namespace,
+		Name:            ing.Name,
+		UID:             ing.UID,
+		ResourceVersion: ing.ResourceVersion,
+	}
+}
+
+// Eventf queues an event for an ingress without blocking, events are dropped
+// if the API server can't keep up
+func (r *eventRecorder) Eventf(ing *extensions.Ingress, eventType, reason, messageFmt string, args ...interface{}) {
+	now := unversioned.Now()
+	event := &api.Event{
+		ObjectMeta: api.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", ing.Name, now.UnixNano()),
+			Namespace: ing.Namespace,
+		},
+		InvolvedObject: ingressReference(ing),
+		Reason:         reason,
+		Message:        fmt.Sprintf(messageFmt, args...),
+		Source:         r.source,
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           eventType,
+	}
+
+	select {
+	case r.queue <- event:
+	default:
+		log.Warnf("Event queue full, dropping event %s for ingress %s", reason, ingressKey(ing))
+	}
+}
+
+// run sends queued events to the API server
+func (r *eventRecorder) run() {
+	for event := range r.queue {
+		if err := r.send(event); err != nil {
+			log.Warnf("Error sending event %s for %s/%s: %s", event.Reason, event.Namespace, event.InvolvedObject.Name, err)
+		}
+	}
+}
+
+func eventKey(event *api.Event) string {
+	return fmt.Sprintf("%s/%s/%s/%s/%s", event.Namespace, event.InvolvedObject.Name, event.Type, event.Reason, event.Message)
+}
+
+func (r *eventRecorder) send(event *api.Event) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	key := eventKey(event)
+	previous, ok := r.recorded[key]
+	if ok && time.Since(previous.lastSent) < eventRepeatInterval {
+		previous.suppressed++
+		return nil
+	}
+
+	client := r.client.Events(event.Namespace)
+	if ok {
+		// update the existing event with the repetitions seen since
+		updated := *previous.event
+		updated.Count += previous.suppressed + 1
+		updated.LastTimestamp = event.LastTimestamp
+		if result, err := client.Update(&updated); err == nil {
+			r.recorded[key] = &recordedEvent{event: result, lastSent: time.Now()}
+			return nil
+		}
+	}
+
+	result, err := client.Create(event)
+	if err != nil {
+		return err
+	}
+	r.recorded[key] = &recordedEvent{event: result, lastSent: time.Now()}
+	r.expire()
+	return nil
+}
+
+// expire forgets events which are no longer rate-limited, the lock has to be
+// held by the caller
+func (r *eventRecorder) expire() {
+	for key, recorded := range r.recorded {
+		if recorded.suppressed == 0 && time.Since(recorded.lastSent) > 2*eventRepeatInterval {
+			delete(r.recorded, key)
+		}
+	}
+}
+
+// recordEvent logs an event for an ingress and sends it to the API server,
+// if this replica is the leader
+func (ip *IngressProxy) recordEvent(ing *extensions.Ingress, eventType, reason, messageFmt string, args ...interface{}) {
+	message := fmt.Sprintf(messageFmt, args...)
+	if eventType == api.EventTypeWarning {
+		log.Warnf("ingress=%s reason=%s msg=%s", ingressKey(ing), reason, message)
+	} else {
+		log.Infof("ingress=%s reason=%s msg=%s", ingressKey(ing), reason, message)
+	}
+
+	if ip.eventRecorder != nil && ip.isLeader() {
+		ip.eventRecorder.Eventf(ing, eventType, reason, "%s", message)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	kube "k8s.io/kubernetes/pkg/client/unversioned"
+)
+
+// fakeEvents records the events created and updated in all namespaces
+type fakeEvents struct {
+	kube.EventInterface
+	created     []*api.Event
+	updated     []*api.Event
+	updateError error
+}
+
+func (f *fakeEvents) Events(namespace string) kube.EventInterface {
+	return f
+}
+
+func (f *fakeEvents) Create(event *api.Event) (*api.Event, error) {
+	f.created = append(f.created, event)
+	return event, nil
+}
+
+func (f *fakeEvents) Update(event *api.Event) (*api.Event, error) {
+	if f.updateError != nil {
+		return nil, f.updateError
+	}
+	f.updated = append(f.updated, event)
+	return event, nil
+}
+
+// sendEvent queues an event and sends it right away
+func sendEvent(t *testing.T, r *eventRecorder, ing *extensions.Ingress, message string) *api.Event {
+	r.Eventf(ing, api.EventTypeWarning, reasonConflictingRule, "%s", message)
+	event := <-r.queue
+	if err := r.send(event); err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+// ageEvent pretends an event has been sent some time ago
+func ageEvent(r *eventRecorder, event *api.Event, age time.Duration) {
+	r.recorded[eventKey(event)].lastSent = time.Now().Add(-age)
+}
+
+func TestEventRecorderSuppressesRepetitions(t *testing.T) {
+	client := &fakeEvents{}
+	r := newEventRecorder(client)
+	ing := &extensions.Ingress{ObjectMeta: api.ObjectMeta{Name: "ingress1", Namespace: "default"}}
+
+	first := sendEvent(t, r, ing, "path '/' is already served")
+	sendEvent(t, r, ing, "path '/' is already served")
+	sendEvent(t, r, ing, "path '/' is already served")
+	if len(client.created) != 1 || len(client.updated) != 0 {
+		t.Fatalf("expected repetitions to be suppressed, got %d created and %d updated", len(client.created), len(client.updated))
+	}
+	if suppressed := r.recorded[eventKey(first)].suppressed; suppressed != 2 {
+		t.Errorf("expected 2 suppressed repetitions, got %d", suppressed)
+	}
+
+	sendEvent(t, r, ing, "path '/api' is already served")
+	if len(client.created) != 2 {
+		t.Errorf("expected an event with another message to be sent, got %d created", len(client.created))
+	}
+
+	// after the interval the existing event is updated with the repetitions
+	ageEvent(r, first, eventRepeatInterval+time.Second)
+	last := sendEvent(t, r, ing, "path '/' is already served")
+	if len(client.updated) != 1 {
+		t.Fatalf("expected the existing event to be updated, got %d updated", len(client.updated))
+	}
+	updated := client.updated[0]
+	if updated.Name != first.Name || updated.Count != 4 {
+		t.Errorf("expected event %s with count 4, got %s with count %d", first.Name, updated.Name, updated.Count)
+	}
+	if !updated.LastTimestamp.Equal(last.LastTimestamp) || !updated.FirstTimestamp.Equal(first.FirstTimestamp) {
+		t.Errorf("expected the timestamps of the first and last event, got %s and %s", updated.FirstTimestamp, updated.LastTimestamp)
+	}
+	if suppressed := r.recorded[eventKey(first)].suppressed; suppressed != 0 {
+		t.Errorf("expected the suppressed repetitions to be reset, got %d", suppressed)
+	}
+}
+
+func TestEventRecorderFailedUpdate(t *testing.T) {
+	client := &fakeEvents{updateError: errors.New("event not found")}
+	r := newEventRecorder(client)
+	ing := &extensions.Ingress{ObjectMeta: api.ObjectMeta{Name: "ingress1", Namespace: "default"}}
+
+	first := sendEvent(t, r, ing, "path '/' is already served")
+	ageEvent(r, first, eventRepeatInterval+time.Second)
+	sendEvent(t, r, ing, "path '/' is already served")
+	if len(client.created) != 2 || client.created[1].Count != 1 {
+		t.Errorf("expected a new event if the existing one can't be updated, got %d created", len(client.created))
+	}
+}
+
+func TestEventRecorderExpire(t *testing.T) {
+	client := &fakeEvents{}
+	r := newEventRecorder(client)
+	ing := &extensions.Ingress{ObjectMeta: api.ObjectMeta{Name: "ingress1", Namespace: "default"}}
+
+	expired := sendEvent(t, r, ing, "path '/' is already served")
+	repeated := sendEvent(t, r, ing, "path '/api' is already served")
+	sendEvent(t, r, ing, "path '/api' is already served")
+	ageEvent(r, expired, 2*eventRepeatInterval+time.Second)
+	ageEvent(r, repeated, 2*eventRepeatInterval+time.Second)
+
+	// expiry runs whenever a new event is created
+	recent := sendEvent(t, r, ing, "path '/web' is already served")
+	if _, ok := r.recorded[eventKey(expired)]; ok {
+		t.Errorf("expected the old event to be forgotten")
+	}
+	if _, ok := r.recorded[eventKey(repeated)]; !ok {
+		t.Errorf("expected the old event with suppressed repetitions to be kept")
+	}
+	if _, ok := r.recorded[eventKey(recent)]; !ok {
+		t.Errorf("expected the recent event to be kept")
+	}
+}
+
+func TestEventQueueFull(t *testing.T) {
+	r := newEventRecorder(&fakeEvents{})
+	ing := &extensions.Ingress{ObjectMeta: api.ObjectMeta{Name: "ingress1", Namespace: "default"}}
+
+	for i := 0; i < eventQueueSize+1; i++ {
+		r.Eventf(ing, api.EventTypeNormal, reasonSync, "Ingress configuration applied")
+	}
+	if len(r.queue) != eventQueueSize {
+		t.Errorf("expected events beyond the queue size to be dropped, got %d queued", len(r.queue))
+	}
+}
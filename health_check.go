@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// Health checks probe the endpoints of a backend balanced across its
+// endpoints, either by a HTTP request to a path, which is healthy if it
+// answers with a 2xx or 3xx status, or by opening a TCP connection. They
+// only narrow down the endpoints Kubernetes considers ready. Endpoints start
+// healthy, turn unhealthy after a number of failed checks in a row and turn
+// healthy again after a number of successful checks. Unhealthy endpoints are
+// out of rotation unless no endpoint is healthy, then all ready endpoints
+// are used. Health checks need the endpoints upstream mode, their type can
+// be set per backend, 'none' turns them off.
+const (
+	healthCheckHTTP = "http"
+	healthCheckTCP  = "tcp"
+	healthCheckNone = "none"
+)
+
+func validateHealthCheckType(value string) error {
+	if value != healthCheckHTTP && value != healthCheckTCP {
+		return fmt.Errorf("unknown health check '%s'", value)
+	}
+	return nil
+}
+
+// healthCheck configures the health checks of a backend, which has no
+// health checks if Type is empty
+type healthCheck struct {
+	Type               string
+	Path               string
+	Interval           time.Duration
+	Timeout            time.Duration
+	HealthyThreshold   int
+	UnhealthyThreshold int
+}
+
+func (c healthCheck) String() string {
+	if c.Type == healthCheckHTTP {
+		return fmt.Sprintf("%s %s every %s", c.Type, c.Path, c.Interval)
+	}
+	return fmt.Sprintf("%s every %s", c.Type, c.Interval)
+}
+
+// healthCheckFor returns the health check of a backend
+func (s *ingressSettings) healthCheckFor(b *extensions.IngressBackend) healthCheck {
+	check := s.HealthCheck
+	if checkType, ok := s.HealthCheckBackends[b.ServiceName+":"+b.ServicePort.String()]; ok {
+		check.Type = checkType
+		if checkType == healthCheckNone {
+			check.Type = ""
+		}
+	}
+	return check
+}
+
+// healthChecked is true if any backend of an ingress has health checks
+func (s *ingressSettings) healthChecked() bool {
+	if len(s.HealthCheck.Type) > 0 {
+		return true
+	}
+	for _, checkType := range s.HealthCheckBackends {
+		if checkType != healthCheckNone {
+			return true
+		}
+	}
+	return false
+}
+
+// key identifies the health check settings
+func (c healthCheck) key() string {
+	return fmt.Sprintf("%s:%s/%s/%s/%d/%d", c.Type, c.Path, c.Interval, c.Timeout, c.HealthyThreshold, c.UnhealthyThreshold)
+}
+
+// healthChecker runs the health checks of the endpoints of a pool. Its
+// metrics are labeled with the key of its proxy, several proxies may check
+// the same service port with different settings.
+type healthChecker struct {
+	check    healthCheck
+	pool     *endpointPool
+	labels   []string
+	client   *http.Client
+	stop     chan struct{}
+	stopOnce sync.Once
+	reported map[string]bool
+}
+
+func newHealthChecker(check healthCheck, pool *endpointPool, b *ingressBackend, proxyKey string) *healthChecker {
+	return &healthChecker{
+		check:    check,
+		pool:     pool,
+		labels:   []string{b.Namespace, b.ServiceName, b.ServicePort.String(), proxyKey},
+		stop:     make(chan struct{}),
+		reported: make(map[string]bool),
+		client: &http.Client{
+			Timeout: check.Timeout,
+			Transport: &http.Transport{
+				DisableKeepAlives: true,
+			},
+			// redirects count as healthy
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+func (h *healthChecker) run() {
+	ticker := time.NewTicker(h.check.Interval)
+	defer ticker.Stop()
+	for {
+		h.checkEndpoints()
+		select {
+		case <-ticker.C:
+		case <-h.stop:
+			h.forget(nil)
+			return
+		}
+	}
+}
+
+func (h *healthChecker) close() {
+	h.stopOnce.Do(func() {
+		close(h.stop)
+	})
+}
+
+// checkEndpoints probes all endpoints at once and applies the results
+func (h *healthChecker) checkEndpoints() {
+	endpoints := h.pool.get()
+	results := make([]error, len(endpoints))
+	var wg sync.WaitGroup
+	for i, e := range endpoints {
+		wg.Add(1)
+		go func(i int, e *endpoint) {
+			defer wg.Done()
+			results[i] = h.probe(e)
+		}(i, e)
+	}
+	wg.Wait()
+
+	changed := false
+	current := make(map[string]bool)
+	for i, e := range endpoints {
+		current[e.address] = true
+		if h.record(e, results[i]) {
+			changed = true
+		}
+	}
+	if changed {
+		h.pool.refresh()
+	}
+	h.forget(current)
+}
+
+// record counts the result of a check, it returns true if the endpoint
+// changed its health
+func (h *healthChecker) record(e *endpoint, err error) bool {
+	labels := append(h.labels, e.address)
+	healthy := e.healthy()
+	changed := false
+
+	if err == nil {
+		e.checkFailures = 0
+		e.checkSuccesses++
+		if !healthy && e.checkSuccesses >= h.check.HealthyThreshold {
+			atomic.StoreInt32(&e.unhealthy, 0)
+			log.Infof("Endpoint %s of service %s/%s is healthy again", e.address, h.labels[0], h.labels[1])
+			changed = true
+		}
+	} else {
+		healthCheckFailuresMetric.WithLabelValues(labels...).Inc()
+		e.checkSuccesses = 0
+		e.checkFailures++
+		if healthy && e.checkFailures >= h.check.UnhealthyThreshold {
+			atomic.StoreInt32(&e.unhealthy, 1)
+			log.Warnf("Endpoint %s of service %s/%s is unhealthy: %s", e.address, h.labels[0], h.labels[1], err)
+			changed = true
+		}
+	}
+
+	value := 0.0
+	if e.healthy() {
+		value = 1
+	}
+	endpointHealthyMetric.WithLabelValues(labels...).Set(value)
+	h.reported[e.address] = true
+	return changed
+}
+
+// forget removes the metrics of endpoints not in current
+func (h *healthChecker) forget(current map[string]bool) {
+	for address := range h.reported {
+		if current[address] {
+			continue
+		}
+		labels := append(h.labels, address)
+		endpointHealthyMetric.DeleteLabelValues(labels...)
+		healthCheckFailuresMetric.DeleteLabelValues(labels...)
+		delete(h.reported, address)
+	}
+}
+
+// probe checks an endpoint once
+func (h *healthChecker) probe(e *endpoint) error {
+	if h.check.Type == healthCheckTCP {
+		conn, err := net.DialTimeout("tcp", e.address, h.check.Timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	resp, err := h.client.Get(fmt.Sprintf("http://%s%s", e.address, h.check.Path))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("health check returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// endpointHealth is the state of an endpoint reported by the admin endpoint
+type endpointHealth struct {
+	Address  string `json:"address"`
+	Healthy  bool   `json:"healthy"`
+	InFlight int64  `json:"inFlight"`
+	Latency  string `json:"latency"`
+}
+
+// backendHealth lists the endpoints of a backend proxy
+type backendHealth struct {
+	Backend     string           `json:"backend"`
+	HealthCheck string           `json:"healthCheck,omitempty"`
+	Endpoints   []endpointHealth `json:"endpoints"`
+}
+
+// endpointsHealth reports the endpoints of all backends balanced across
+// their endpoints in the current snapshot
+func (ip *IngressProxy) endpointsHealth() []backendHealth {
+	backends := []backendHealth{}
+	for _, proxy := range ip.currentSnapshot().proxies {
+		if proxy.endpoints == nil {
+			continue
+		}
+		backend := backendHealth{
+			Backend:   proxy.key,
+			Endpoints: []endpointHealth{},
+		}
+		if proxy.health != nil {
+			backend.HealthCheck = proxy.health.check.String()
+		}
+		for _, e := range proxy.endpoints.get() {
+			e.lock.Lock()
+			latency := e.latency
+			e.lock.Unlock()
+			backend.Endpoints = append(backend.Endpoints, endpointHealth{
+				Address:  e.address,
+				Healthy:  e.healthy(),
+				InFlight: e.inFlight(),
+				Latency:  latency.String(),
+			})
+		}
+		backends = append(backends, backend)
+	}
+	sort.Sort(backendHealthByName(backends))
+	return backends
+}
+
+type backendHealthByName []backendHealth
+
+func (b backendHealthByName) Len() int           { return len(b) }
+func (b backendHealthByName) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b backendHealthByName) Less(i, j int) bool { return b[i].Backend < b[j].Backend }
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/util/intstr"
+)
+
+// checkedBackend answers requests with its name and health checks with 500
+// while failing is set
+func checkedBackend(name string, failing *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" && atomic.LoadInt32(failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(name))
+	}))
+}
+
+func eventually(t *testing.T, condition func() bool, msg string) {
+	for timeout := time.Now().Add(5 * time.Second); !condition(); {
+		if time.Now().After(timeout) {
+			t.Fatal(msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func endpointHealthyValue(t *testing.T, proxy *backendProxy, address string) float64 {
+	m := &dto.Metric{}
+	if err := endpointHealthyMetric.WithLabelValues("default", "web", "http", proxy.key, address).Write(m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetGauge().GetValue()
+}
+
+func TestHealthCheckRotation(t *testing.T) {
+	var failing1, failing2 int32
+	backend1 := checkedBackend("backend1", &failing1)
+	defer backend1.Close()
+	backend2 := checkedBackend("backend2", &failing2)
+	defer backend2.Close()
+	address2 := backend2.Listener.Addr().String()
+
+	ip := exampleServicePorts(t)
+	ip.endpointsCache = syncedCache(t, "endpoints", exampleEndpoints(t, backend1.Listener.Addr().String(), address2))
+	ing := exampleEndpointsIngress()
+	ing.Annotations[annotationPrefix+"health-check"] = healthCheckHTTP
+	ing.Annotations[annotationPrefix+"health-check-path"] = "/healthz"
+	ing.Annotations[annotationPrefix+"health-check-interval"] = "10ms"
+	ing.Annotations[annotationPrefix+"health-check-unhealthy-threshold"] = "2"
+	ip.SetIngresses([]*extensions.Ingress{ing})
+
+	proxy := ip.routeRequestToBackend(httptest.NewRequest("GET", "http://www.test.de/", nil)).Proxy
+	inRotation := func(address string) bool {
+		for _, e := range proxy.endpoints.getAvailable() {
+			if e.address == address {
+				return true
+			}
+		}
+		return false
+	}
+
+	// an endpoint failing its health checks is taken out of rotation
+	atomic.StoreInt32(&failing2, 1)
+	eventually(t, func() bool {
+		return !inRotation(address2) && endpointHealthyValue(t, proxy, address2) == 0
+	}, "failing endpoint not taken out of rotation")
+	if hits := backendHits(ip, 4); hits["backend1"] != 4 {
+		t.Errorf("requests sent to an unhealthy endpoint: %v", hits)
+	}
+
+	w := httptest.NewRecorder()
+	ip.adminMux().ServeHTTP(w, httptest.NewRequest("GET", "/endpoints", nil))
+	var backends []backendHealth
+	if err := json.NewDecoder(w.Body).Decode(&backends); err != nil {
+		t.Fatal(err)
+	}
+	if len(backends) != 1 || len(backends[0].Endpoints) != 2 || backends[0].HealthCheck != "http /healthz every 10ms" {
+		t.Fatalf("unexpected endpoints %+v", backends)
+	}
+	for _, e := range backends[0].Endpoints {
+		if e.Healthy != (e.Address != address2) {
+			t.Errorf("endpoint %s reported with healthy=%t", e.Address, e.Healthy)
+		}
+	}
+
+	// without healthy endpoints all ready endpoints are used
+	atomic.StoreInt32(&failing1, 1)
+	eventually(t, func() bool {
+		return endpointHealthyValue(t, proxy, backend1.Listener.Addr().String()) == 0 && inRotation(address2)
+	}, "endpoints not back in rotation without healthy ones")
+	if hits := backendHits(ip, 4); hits["backend1"] != 2 || hits["backend2"] != 2 {
+		t.Errorf("requests not sent to all endpoints without healthy ones: %v", hits)
+	}
+
+	// recovered endpoints return after passing their checks
+	atomic.StoreInt32(&failing1, 0)
+	atomic.StoreInt32(&failing2, 0)
+	eventually(t, func() bool {
+		return endpointHealthyValue(t, proxy, address2) == 1 && endpointHealthyValue(t, proxy, backend1.Listener.Addr().String()) == 1
+	}, "recovered endpoints not reported healthy")
+
+	// the checks of removed backends stop
+	ip.SetIngresses(nil)
+	select {
+	case <-proxy.health.stop:
+	default:
+		t.Error("health checks of a stale proxy not stopped")
+	}
+}
+
+func newTCPHealthChecker(pool *endpointPool, proxyKey string) *healthChecker {
+	return newHealthChecker(healthCheck{
+		Type:               healthCheckTCP,
+		Timeout:            time.Second,
+		HealthyThreshold:   1,
+		UnhealthyThreshold: 1,
+	}, pool, &ingressBackend{
+		IngressBackend: &extensions.IngressBackend{ServiceName: "tcp", ServicePort: intstr.FromInt(80)},
+		Namespace:      "default",
+	}, proxyKey)
+}
+
+func TestTCPHealthCheck(t *testing.T) {
+	backend := namedBackend("backend")
+	defer backend.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := listener.Addr().String()
+	listener.Close()
+
+	pool := newEndpointPool(&roundRobinBalancer{})
+	pool.set([]endpointAddress{{Address: backend.Listener.Addr().String()}, {Address: dead}}, nil)
+	h := newTCPHealthChecker(pool, "tcp")
+	defer h.forget(nil)
+
+	h.checkEndpoints()
+	available := pool.getAvailable()
+	if len(available) != 1 || available[0].address != backend.Listener.Addr().String() {
+		t.Errorf("expected only the listening endpoint to be available, got %d endpoints", len(available))
+	}
+}
+
+func TestHealthCheckAnnotations(t *testing.T) {
+	ing := exampleEndpointsIngress()
+	ing.Annotations[annotationPrefix+"health-check"] = healthCheckTCP
+	ing.Annotations[annotationPrefix+"health-check-healthy-threshold"] = "5"
+	settings, problems := parseIngressSettings(ing, NewConfig())
+	if len(problems) != 0 {
+		t.Fatalf("unexpected problems %v", problems)
+	}
+	if c := settings.HealthCheck; c.Type != healthCheckTCP || c.HealthyThreshold != 5 || c.UnhealthyThreshold != 3 || c.Interval != 10*time.Second {
+		t.Errorf("unexpected health check %+v", c)
+	}
+
+	for annotation, value := range map[string]string{
+		"health-check":                     "grpc",
+		"health-check-path":                "healthz",
+		"health-check-timeout":             "0s",
+		"health-check-unhealthy-threshold": "-1",
+	} {
+		ing := exampleEndpointsIngress()
+		ing.Annotations[annotationPrefix+annotation] = value
+		if _, problems := parseIngressSettings(ing, NewConfig()); len(problems) != 1 || !strings.Contains(problems[0].Message, annotation) {
+			t.Errorf("invalid %s '%s' not reported: %v", annotation, value, problems)
+		}
+	}
+}
+
+func TestHealthCheckMetricsPerProxy(t *testing.T) {
+	backend := namedBackend("backend")
+	defer backend.Close()
+	address := backend.Listener.Addr().String()
+
+	// two proxies checking the same service port keep their own series
+	checkers := []*healthChecker{}
+	for _, key := range []string{"proxy1", "proxy2"} {
+		pool := newEndpointPool(&roundRobinBalancer{})
+		pool.set([]endpointAddress{{Address: address}}, nil)
+		h := newTCPHealthChecker(pool, key)
+		defer h.forget(nil)
+		h.checkEndpoints()
+		checkers = append(checkers, h)
+	}
+
+	checkers[0].forget(nil)
+	m := &dto.Metric{}
+	if err := endpointHealthyMetric.WithLabelValues("default", "tcp", "80", "proxy2", address).Write(m); err != nil {
+		t.Fatal(err)
+	}
+	if m.GetGauge().GetValue() != 1 {
+		t.Error("series of another proxy removed by a stale checker")
+	}
+	if endpointHealthyMetric.DeleteLabelValues("default", "tcp", "80", "proxy1", address) {
+		t.Error("series of a stale checker not removed")
+	}
+}
+
+func TestHealthCheckBackends(t *testing.T) {
+	ing := exampleEndpointsIngress()
+	ing.Annotations[annotationPrefix+"health-check"] = healthCheckHTTP
+	ing.Annotations[annotationPrefix+"health-check-backends"] = "web:http=tcp, other:80=none"
+	settings, problems := parseIngressSettings(ing, NewConfig())
+	if len(problems) != 0 {
+		t.Fatalf("unexpected problems %v", problems)
+	}
+	for backend, expected := range map[extensions.IngressBackend]string{
+		{ServiceName: "web", ServicePort: intstr.FromString("http")}: healthCheckTCP,
+		{ServiceName: "other", ServicePort: intstr.FromInt(80)}:      "",
+		{ServiceName: "web", ServicePort: intstr.FromInt(80)}:        healthCheckHTTP,
+	} {
+		if check := settings.healthCheckFor(&backend); check.Type != expected || check.Interval != 10*time.Second {
+			t.Errorf("backend %s:%s got health check %+v, expected type '%s'", backend.ServiceName, backend.ServicePort.String(), check, expected)
+		}
+	}
+
+	for _, value := range []string{"web=tcp", "web:http=grpc", ","} {
+		ing.Annotations[annotationPrefix+"health-check-backends"] = value
+		if _, problems := parseIngressSettings(ing, NewConfig()); len(problems) != 1 {
+			t.Errorf("invalid backends '%s' not reported: %v", value, problems)
+		}
+	}
+}
+
+func TestHealthCheckNeedsEndpoints(t *testing.T) {
+	ip := exampleServicePorts(t)
+	ing := exampleEndpointsIngress()
+	ing.Annotations[annotationPrefix+"upstream-mode"] = upstreamModeService
+	ing.Annotations[annotationPrefix+"health-check-backends"] = "web:http=tcp"
+	problems := ip.validateIngress(ing)
+	if len(problems) != 1 || problems[0].Reason != reasonInvalidAnnotation || !strings.Contains(problems[0].Message, "health-check") {
+		t.Errorf("health checks without upstream mode endpoints not reported: %v", problems)
+	}
+
+	ing.Annotations[annotationPrefix+"health-check-backends"] = "web:http=none"
+	if problems := ip.validateIngress(ing); len(problems) != 0 {
+		t.Errorf("unexpected problems %v", problems)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// normalizeHost brings a rule host or a request's Host header into the form
+// used for matching: without port and trailing dot, lower case and with
+// internationalized labels in their punycode form
+func normalizeHost(host string) string {
+	host = stripPort(host)
+	host = strings.TrimSuffix(host, ".")
+	host = strings.ToLower(host)
+	return hostToASCII(host)
+}
+
+// stripPort removes the port from a host, IPv6 addresses have to be in
+// brackets if a port is given
+func stripPort(host string) string {
+	if strings.HasPrefix(host, "[") {
+		if end := strings.Index(host, "]"); end > 0 {
+			return host[1:end]
+		}
+		return host
+	}
+	if strings.Count(host, ":") == 1 {
+		return host[:strings.Index(host, ":")]
+	}
+	return host
+}
+
+// validateHost checks a rule host, wildcards are only allowed as the first
+// label
+func validateHost(host string) error {
+	normalized := normalizeHost(host)
+	if strings.Contains(normalized, "*") {
+		if !strings.HasPrefix(normalized, "*.") || strings.Count(normalized, "*") > 1 || len(normalized) < 3 {
+			return fmt.Errorf("host '%s' has to start with '*.' and contain a single wildcard", host)
+		}
+	}
+	for _, label := range strings.Split(normalized, ".") {
+		if len(label) == 0 {
+			return fmt.Errorf("host '%s' contains an empty label", host)
+		}
+		if len(label) > 63 {
+			return fmt.Errorf("host '%s' contains a label longer than 63 characters", host)
+		}
+	}
+	return nil
+}
+
+// hostToASCII converts labels with non-ASCII characters to punycode
+func hostToASCII(host string) string {
+	ascii := true
+	for i := 0; i < len(host); i++ {
+		if host[i] >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return host
+	}
+
+	labels := strings.Split(host, ".")
+	for i, label := range labels {
+		for _, c := range label {
+			if c >= utf8.RuneSelf {
+				labels[i] = "xn--" + punycodeEncode(label)
+				break
+			}
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+// Parameters of the punycode encoding, see RFC 3492
+const (
+	punycodeBase        = 36
+	punycodeTMin        = 1
+	punycodeTMax        = 26
+	punycodeSkew        = 38
+	punycodeDamp        = 700
+	punycodeInitialBias = 72
+	punycodeInitialN    = 128
+)
+
+// punycodeEncode encodes a single label as described in RFC 3492, without
+// the 'xn--' prefix
+func punycodeEncode(label string) string {
+	input := []rune(label)
+	output := []byte{}
+
+	for _, c := range input {
+		if c < utf8.RuneSelf {
+			output = append(output, byte(c))
+		}
+	}
+	basic := len(output)
+	handled := basic
+	if basic > 0 {
+		output = append(output, '-')
+	}
+
+	n := rune(punycodeInitialN)
+	delta := 0
+	bias := punycodeInitialBias
+
+	for handled < len(input) {
+		// the next smallest code point to handle
+		m := rune(utf8.MaxRune)
+		for _, c := range input {
+			if c >= n && c < m {
+				m = c
+			}
+		}
+		delta += int(m-n) * (handled + 1)
+		n = m
+
+		for _, c := range input {
+			if c < n {
+				delta++
+			}
+			if c != n {
+				continue
+			}
+
+			q := delta
+			for k := punycodeBase; ; k += punycodeBase {
+				t := k - bias
+				if t < punycodeTMin {
+					t = punycodeTMin
+				} else if t > punycodeTMax {
+					t = punycodeTMax
+				}
+				if q < t {
+					break
+				}
+				output = append(output, punycodeDigit(t+(q-t)%(punycodeBase-t)))
+				q = (q - t) / (punycodeBase - t)
+			}
+			output = append(output, punycodeDigit(q))
+
+			bias = punycodeAdapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+
+		delta++
+		n++
+	}
+
+	return string(output)
+}
+
+func punycodeAdapt(delta, numPoints int, first bool) int {
+	if first {
+		delta /= punycodeDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+
+	k := 0
+	for delta > ((punycodeBase-punycodeTMin)*punycodeTMax)/2 {
+		delta /= punycodeBase - punycodeTMin
+		k += punycodeBase
+	}
+	return k + (punycodeBase-punycodeTMin+1)*delta/(delta+punycodeSkew)
+}
+
+func punycodeDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNormalizeHost(t *testing.T) {
+	for _, test := range []struct {
+		host, expected string
+	}{
+		{"www.test.de", "www.test.de"},
+		{"WWW.Test.De:8080", "www.test.de"},
+		{"www.test.de.", "www.test.de"},
+		{"[::1]:8080", "::1"},
+		{"::1", "::1"},
+		{"bücher.example", "xn--bcher-kva.example"},
+		{"münchen.de", "xn--mnchen-3ya.de"},
+		{"例え.テスト", "xn--r8jz45g.xn--zckzah"},
+		{"*.bücher.example", "*.xn--bcher-kva.example"},
+	} {
+		if normalized := normalizeHost(test.host); normalized != test.expected {
+			t.Errorf("host %s normalized to %s, expected %s", test.host, normalized, test.expected)
+		}
+	}
+}
+
+func TestValidateHost(t *testing.T) {
+	for _, host := range []string{"www.test.de", "*.test.de", "bücher.example"} {
+		if err := validateHost(host); err != nil {
+			t.Errorf("host %s should be valid: %s", host, err)
+		}
+	}
+	for _, host := range []string{"*", "*.", "www.*.de", "*.*.de", "www..de", "*test.de"} {
+		if err := validateHost(host); err == nil {
+			t.Errorf("host %s should be invalid", host)
+		}
+	}
+}
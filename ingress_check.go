@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"k8s.io/kubernetes/pkg/api"
+	apierrors "k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// Reasons of events recorded against ingresses
+const (
+	reasonSync            = "Sync"
+	reasonMissingSecret   = "MissingSecret"
+	reasonInvalidSecret   = "InvalidSecret"
+	reasonUnknownService  = "UnknownService"
+	reasonUnknownPort     = "UnknownServicePort"
+	reasonInvalidPath     = "InvalidPath"
+	reasonInvalidHost     = "InvalidHost"
+	reasonConflictingRule = "ConflictingRule"
+	reasonNoEndpoints     = "NoEndpoints"
+)
+
+// ingressProblem is a configuration problem found in an ingress
+type ingressProblem struct {
+	Reason  string
+	Message string
+}
+
+func (p ingressProblem) String() string {
+	return fmt.Sprintf("%s: %s", p.Reason, p.Message)
+}
+
+// ingressBackends returns all backends referenced by an ingress
+func ingressBackends(ing *extensions.Ingress) []*extensions.IngressBackend {
+	backends := []*extensions.IngressBackend{}
+	if ing.Spec.Backend != nil {
+		backends = append(backends, ing.Spec.Backend)
+	}
+	for _, rule := range ing.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for i := range rule.HTTP.Paths {
+			backends = append(backends, &rule.HTTP.Paths[i].Backend)
+		}
+	}
+	return backends
+}
+
+// validateIngress looks for problems which prevent an ingress from being
+// applied. Services and secrets are only checked when connected to the API
+// server.
+func (ip *IngressProxy) validateIngress(ing *extensions.Ingress) []ingressProblem {
+	problems := []ingressProblem{}
+
+	for _, rule := range ing.Spec.Rules {
+		if len(rule.Host) > 0 {
+			if err := validateHost(rule.Host); err != nil {
+				problems = append(problems, ingressProblem{reasonInvalidHost, err.Error()})
+			}
+		}
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			if len(path.Path) > 0 && !strings.HasPrefix(path.Path, "/") {
+				problems = append(problems, ingressProblem{
+					reasonInvalidPath,
+					fmt.Sprintf("path '%s' of host '%s' does not start with '/'", path.Path, rule.Host),
+				})
+			}
+		}
+	}
+
+	c := ip.currentConfig()
+	settings, invalid := checkSettings(ing, c.Config)
+	problems = append(problems, invalid...)
+
+	if ip.kubeClient == nil {
+		// named ports can only be resolved through the service and endpoints
+		// can only be followed through the API server
+		for _, backend := range ingressBackends(ing) {
+			if _, overridden := c.backendOverride(&ingressBackend{IngressBackend: backend, Namespace: ing.Namespace}); overridden {
+				continue
+			}
+			if settings.UpstreamMode == upstreamModeEndpoints {
+				problems = append(problems, ingressProblem{
+					reasonNoEndpoints,
+					fmt.Sprintf("endpoints of service '%s/%s' can't be followed without API server", ing.Namespace, backend.ServiceName),
+				})
+				continue
+			}
+			if _, err := ip.resolveServicePort(ing.Namespace, backend); err != nil {
+				problems = append(problems, ingressProblem{reasonUnknownPort, err.Error()})
+			}
+		}
+		if len(settings.AuthSecret) > 0 {
+			problems = append(problems, ingressProblem{
+				reasonMissingSecret,
+				fmt.Sprintf("auth secret '%s/%s' can't be read without API server", ing.Namespace, settings.AuthSecret),
+			})
+		}
+		return problems
+	}
+
+	services := make(map[string]*api.Service)
+	checkedPorts := make(map[string]bool)
+	for _, backend := range ingressBackends(ing) {
+		service, checked := services[backend.ServiceName]
+		if !checked {
+			var err error
+			service, err = ip.getService(ing.Namespace, backend.ServiceName)
+			if apierrors.IsNotFound(err) {
+				problems = append(problems, ingressProblem{
+					reasonUnknownService,
+					fmt.Sprintf("service '%s/%s' not found", ing.Namespace, backend.ServiceName),
+				})
+				service = nil
+			} else if err != nil {
+				log.Warnf("Error checking service '%s/%s': %s", ing.Namespace, backend.ServiceName, err)
+				service = nil
+			}
+			services[backend.ServiceName] = service
+		}
+
+		portKey := fmt.Sprintf("%s:%s", backend.ServiceName, backend.ServicePort.String())
+		if service == nil || checkedPorts[portKey] {
+			continue
+		}
+		checkedPorts[portKey] = true
+		if !serviceHasPort(service, backend.ServicePort) {
+			problems = append(problems, ingressProblem{
+				reasonUnknownPort,
+				fmt.Sprintf("service '%s/%s' has no port '%s'", ing.Namespace, backend.ServiceName, backend.ServicePort.String()),
+			})
+		}
+	}
+
+	for _, tls := range ing.Spec.TLS {
+		if len(tls.SecretName) == 0 {
+			continue
+		}
+		secret, err := ip.getSecret(ing.Namespace, tls.SecretName)
+		if apierrors.IsNotFound(err) {
+			problems = append(problems, ingressProblem{
+				reasonMissingSecret,
+				fmt.Sprintf("TLS secret '%s/%s' not found", ing.Namespace, tls.SecretName),
+			})
+			continue
+		} else if err != nil {
+			log.Warnf("Error checking secret '%s/%s': %s", ing.Namespace, tls.SecretName, err)
+			continue
+		}
+		if len(secret.Data[api.TLSCertKey]) == 0 || len(secret.Data[api.TLSPrivateKeyKey]) == 0 {
+			problems = append(problems, ingressProblem{
+				reasonInvalidSecret,
+				fmt.Sprintf("TLS secret '%s/%s' has no %s or %s", ing.Namespace, tls.SecretName, api.TLSCertKey, api.TLSPrivateKeyKey),
+			})
+		}
+	}
+
+	if len(settings.AuthSecret) > 0 {
+		secret, err := ip.getSecret(ing.Namespace, settings.AuthSecret)
+		if apierrors.IsNotFound(err) {
+			problems = append(problems, ingressProblem{
+				reasonMissingSecret,
+				fmt.Sprintf("auth secret '%s/%s' not found", ing.Namespace, settings.AuthSecret),
+			})
+		} else if err != nil {
+			log.Warnf("Error checking secret '%s/%s': %s", ing.Namespace, settings.AuthSecret, err)
+		} else if _, err := parseAuthSecret(secret); err != nil {
+			problems = append(problems, ingressProblem{
+				reasonInvalidSecret,
+				fmt.Sprintf("auth secret '%s/%s' has %s", ing.Namespace, settings.AuthSecret, err),
+			})
+		}
+	}
+
+	return problems
+}
+
+// checkSettings parses the settings of an ingress and reports invalid ones
+func checkSettings(ing *extensions.Ingress, c *Config) (*ingressSettings, []ingressProblem) {
+	settings, problems := parseIngressSettings(ing, c)
+	if settings.healthChecked() && settings.UpstreamMode != upstreamModeEndpoints {
+		problems = append(problems, ingressProblem{
+			reasonInvalidAnnotation,
+			fmt.Sprintf("annotation %shealth-check: health checks need upstream mode '%s'", annotationPrefix, upstreamModeEndpoints),
+		})
+	}
+	return settings, problems
+}
+
+// rejectIngress reports an ingress which couldn't be applied, the previous
+// version of it keeps serving
+func (ip *IngressProxy) rejectIngress(ing *extensions.Ingress, problems []ingressProblem) {
+	for _, problem := range problems {
+		ip.recordEvent(ing, api.EventTypeWarning, problem.Reason, "%s, keeping the previous configuration", problem.Message)
+	}
+	ingressRejectionsMetric.WithLabelValues(ing.Namespace, ing.Name).Inc()
+	ingressRejectedMetric.WithLabelValues(ing.Namespace, ing.Name).Set(1)
+	ip.holdIngress(ing)
+}
+
+// holdIngress remembers an ingress which hasn't been applied, so it can be
+// retried when services or secrets change
+func (ip *IngressProxy) holdIngress(ing *extensions.Ingress) {
+	ip.rejectedLock.Lock()
+	ip.rejected[ingressKey(ing)] = ing
+	ip.rejectedLock.Unlock()
+}
+
+// acceptIngress forgets that an earlier version of an ingress was rejected
+func (ip *IngressProxy) acceptIngress(ing *extensions.Ingress) {
+	ingressRejectedMetric.WithLabelValues(ing.Namespace, ing.Name).Set(0)
+
+	ip.rejectedLock.Lock()
+	delete(ip.rejected, ingressKey(ing))
+	ip.rejectedLock.Unlock()
+}
+
+// rejectedIngresses returns the latest versions of rejected ingresses
+func (ip *IngressProxy) rejectedIngresses() []*extensions.Ingress {
+	ip.rejectedLock.Lock()
+	defer ip.rejectedLock.Unlock()
+
+	ingresses := make([]*extensions.Ingress, 0, len(ip.rejected))
+	for _, ing := range ip.rejected {
+		ingresses = append(ingresses, ing)
+	}
+	return ingresses
+}
+
+// checkConflicts reports rules of an ingress shadowed by ingresses with
+// higher precedence
+func (ip *IngressProxy) checkConflicts(ing *extensions.Ingress) []ingressProblem {
+	problems := []ingressProblem{}
+	key := ingressKey(ing)
+
+	for _, rule := range ip.getShadowedRules() {
+		if rule.Ingress != key {
+			continue
+		}
+		message := fmt.Sprintf("host '%s' path '%s' is already served by ingress %s", rule.Host, rule.Path, rule.ShadowedBy)
+		if rule.isDefaultBackend() {
+			message = fmt.Sprintf("default backend is overridden by ingress %s", rule.ShadowedBy)
+		}
+		problems = append(problems, ingressProblem{reasonConflictingRule, message})
+	}
+
+	return problems
+}
+
+// queueIngressCheck schedules an applied ingress to be checked and reported
+func (ip *IngressProxy) queueIngressCheck(ing *extensions.Ingress) {
+	ip.checkQueue.Add(ingressKey(ing))
+}
+
+// runIngressChecks reports conflicts, unknown annotations and settings made
+// invalid by a changed global config of applied ingresses through events,
+// repeated updates of the same ingress are coalesced by the queue
+func (ip *IngressProxy) runIngressChecks() {
+	for {
+		item, shutdown := ip.checkQueue.Get()
+		if shutdown {
+			return
+		}
+		ip.checkIngress(item.(string))
+		ip.checkQueue.Done(item)
+	}
+}
+
+// checkIngress reports the applied version of an ingress
+func (ip *IngressProxy) checkIngress(key string) {
+	if ing := ip.storedIngress(key); ing != nil {
+		ip.reportIngress(ing)
+	}
+}
+
+func (ip *IngressProxy) reportIngress(ing *extensions.Ingress) {
+	problems := append(ip.checkConflicts(ing), unknownAnnotations(ing)...)
+	// the settings were valid when the ingress was applied, the global config
+	// may have changed since
+	_, invalid := checkSettings(ing, ip.currentConfig().Config)
+	for _, problem := range invalid {
+		problem.Message += ", the default is used since the global config changed"
+		problems = append(problems, problem)
+	}
+	for _, problem := range problems {
+		ip.recordEvent(ing, api.EventTypeWarning, problem.Reason, "%s", problem.Message)
+	}
+	if len(problems) == 0 {
+		ip.recordEvent(ing, api.EventTypeNormal, reasonSync, "Ingress configuration applied")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/util/intstr"
+)
+
+func TestCheckIngress(t *testing.T) {
+	ip := exampleIngress()
+
+	if problems := ip.validateIngress(ip.ingresses()[0]); len(problems) != 0 {
+		t.Errorf("expected no problems for the example ingress, got %v", problems)
+	}
+
+	conflicting := &extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "ingress2",
+			Namespace: "default",
+		},
+		Spec: extensions.IngressSpec{
+			Rules: []extensions.IngressRule{
+				extensions.IngressRule{
+					Host: "www.test.de",
+					IngressRuleValue: extensions.IngressRuleValue{
+						HTTP: &extensions.HTTPIngressRuleValue{
+							Paths: []extensions.HTTPIngressPath{
+								extensions.HTTPIngressPath{
+									Path: "/",
+									Backend: extensions.IngressBackend{
+										ServiceName: "service6",
+										ServicePort: intstr.FromInt(8080),
+									},
+								},
+								extensions.HTTPIngressPath{
+									Path: "api",
+									Backend: extensions.IngressBackend{
+										ServiceName: "service6",
+										ServicePort: intstr.FromInt(8080),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	ip.SetIngresses(append(ip.ingresses(), conflicting))
+
+	reasons := map[string]bool{}
+	problems := append(ip.validateIngress(conflicting), ip.checkConflicts(conflicting)...)
+	for _, problem := range problems {
+		reasons[problem.Reason] = true
+	}
+	for _, reason := range []string{reasonConflictingRule, reasonInvalidPath} {
+		if !reasons[reason] {
+			t.Errorf("expected a %s problem for ingress2, got %v", reason, reasons)
+		}
+	}
+
+	if problems := ip.checkConflicts(ip.ingresses()[0]); len(problems) != 0 {
+		t.Errorf("the ingress consulted first should not conflict, got %v", problems)
+	}
+}
+
+func TestRejectInvalidIngress(t *testing.T) {
+	ip := exampleIngress()
+	ip.storeIngress(ip.ingresses()[0])
+
+	copied, err := api.Scheme.DeepCopy(ip.ingresses()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken := copied.(*extensions.Ingress)
+	broken.ResourceVersion = "2"
+	broken.Spec.Rules[0].HTTP.Paths[0].Path = "broken"
+	broken.Spec.Rules[0].HTTP.Paths[0].Backend.ServiceName = "service6"
+
+	ip.storeIngress(broken)
+
+	r, _ := http.NewRequest("GET", "http://www.test.de/", nil)
+	if b := ip.routeRequestToBackend(r); b == nil || b.ServiceName != "service2" {
+		t.Errorf("expected the last-known-good ingress to keep routing to service2, got %v", b)
+	}
+
+	ip.replaceIngresses(api.NamespaceAll, []*extensions.Ingress{broken})
+	if b := ip.routeRequestToBackend(r); b == nil || b.ServiceName != "service2" {
+		t.Errorf("expected a relist to keep the last-known-good ingress, got %v", b)
+	}
+}
+
+func TestRejectInvalidAnnotation(t *testing.T) {
+	ip := exampleIngress()
+	ip.storeIngress(ip.ingresses()[0])
+
+	copied, err := api.Scheme.DeepCopy(ip.ingresses()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalid := copied.(*extensions.Ingress)
+	invalid.ResourceVersion = "2"
+	invalid.Annotations = map[string]string{annotationPrefix + "upstream-timeout": "soon"}
+	invalid.Spec.Rules[0].HTTP.Paths[0].Backend.ServiceName = "service6"
+
+	problems := ip.validateIngress(invalid)
+	if len(problems) != 1 || problems[0].Reason != reasonInvalidAnnotation {
+		t.Fatalf("expected an invalid annotation, got %v", problems)
+	}
+
+	ip.storeIngress(invalid)
+	if stored := ip.storedIngress(ingressKey(invalid)); stored.ResourceVersion == "2" {
+		t.Errorf("the version with an invalid annotation should have been rejected")
+	}
+	r, _ := http.NewRequest("GET", "http://www.test.de/", nil)
+	if b := ip.routeRequestToBackend(r); b == nil || b.ServiceName != "service2" {
+		t.Errorf("expected the last-known-good ingress to keep routing to service2, got %v", b)
+	}
+}
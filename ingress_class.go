@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/labels"
+)
+
+// ingressClassAnnotation selects the controller responsible for an ingress
+const ingressClassAnnotation = "kubernetes.io/ingress.class"
+
+// claimsIngress decides if an ingress is served by this proxy. If not, the
+// reason is returned as well.
+func (ip *IngressProxy) claimsIngress(ing *extensions.Ingress) (bool, string) {
+	c := ip.currentConfig()
+	if c.selector != nil && !c.selector.Matches(labels.Set(ing.Labels)) {
+		return false, fmt.Sprintf("labels do not match selector '%s'", c.selector)
+	}
+
+	if len(c.IngressClass) == 0 {
+		return true, ""
+	}
+
+	class, ok := ing.Annotations[ingressClassAnnotation]
+	if !ok {
+		if c.IngressClassRequired {
+			return false, fmt.Sprintf("annotation %s is missing", ingressClassAnnotation)
+		}
+		return true, ""
+	}
+
+	if class != c.IngressClass {
+		return false, fmt.Sprintf("ingress class '%s' does not match '%s'", class, c.IngressClass)
+	}
+
+	return true, ""
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/util/yaml"
+)
+
+// ingressFileExtensions are the manifest files read from a directory
+var ingressFileExtensions = []string{".yaml", ".yml", ".json"}
+
+// ingressFileSource reads ingresses from manifests on disk instead of the API
+// server. A single file or all manifests in a directory are read and
+// re-read whenever they change.
+type ingressFileSource struct {
+	ip               *IngressProxy
+	path             string
+	interval         time.Duration
+	defaultNamespace string
+	fingerprint      string
+}
+
+func newIngressFileSource(ip *IngressProxy, path string, interval time.Duration) *ingressFileSource {
+	defaultNamespace := api.NamespaceDefault
+	if len(ip.IngressNamespaces) > 0 && ip.IngressNamespaces[0] != api.NamespaceAll {
+		defaultNamespace = ip.IngressNamespaces[0]
+	}
+
+	return &ingressFileSource{
+		ip:               ip,
+		path:             path,
+		interval:         interval,
+		defaultNamespace: defaultNamespace,
+	}
+}
+
+// run polls the manifests for changes, a broken manifest keeps the previous
+// config serving
+func (s *ingressFileSource) run() {
+	for {
+		time.Sleep(s.interval)
+
+		files, fingerprint, err := s.files()
+		if err != nil {
+			log.Warnf("Checking ingress files in '%s' failed: %s", s.path, err)
+			continue
+		}
+		if fingerprint == s.fingerprint {
+			continue
+		}
+
+		log.Infof("Ingress files in '%s' changed, reloading", s.path)
+		if err := s.load(files, fingerprint); err != nil {
+			log.Warnf("Reloading ingress files failed: %s", err)
+		}
+	}
+}
+
+// list reads all manifests once
+func (s *ingressFileSource) list() error {
+	files, fingerprint, err := s.files()
+	if err != nil {
+		return err
+	}
+	return s.load(files, fingerprint)
+}
+
+// files returns the manifest files and a fingerprint of their modification
+// times and sizes
+func (s *ingressFileSource) files() ([]string, string, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	files := []string{s.path}
+	if info.IsDir() {
+		files = []string{}
+		for _, ext := range ingressFileExtensions {
+			matches, err := filepath.Glob(filepath.Join(s.path, "*"+ext))
+			if err != nil {
+				return nil, "", err
+			}
+			files = append(files, matches...)
+		}
+		sort.Strings(files)
+	}
+
+	var fingerprint bytes.Buffer
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, "", err
+		}
+		fmt.Fprintf(&fingerprint, "%s:%d:%d;", file, info.ModTime().UnixNano(), info.Size())
+	}
+
+	return files, fingerprint.String(), nil
+}
+
+func (s *ingressFileSource) load(files []string, fingerprint string) error {
+	ingresses := []*extensions.Ingress{}
+	for _, file := range files {
+		fileIngresses, err := s.decodeFile(file)
+		if err != nil {
+			return fmt.Errorf("Error reading '%s': %s", file, err)
+		}
+		ingresses = append(ingresses, fileIngresses...)
+	}
+
+	claimed := make([]*extensions.Ingress, 0, len(ingresses))
+	for _, ing := range ingresses {
+		if ok, reason := s.ip.claimsIngress(ing); !ok {
+			log.Infof("Ignoring ingress %s: %s", ingressKey(ing), reason)
+			continue
+		}
+		claimed = append(claimed, ing)
+	}
+
+	log.Infof("Read %d ingresses from '%s'", len(claimed), s.path)
+	s.ip.replaceIngresses(api.NamespaceAll, claimed)
+	s.fingerprint = fingerprint
+	return nil
+}
+
+// decodeFile decodes all Ingress and IngressList documents of a manifest
+// file, other kinds are skipped
+func (s *ingressFileSource) decodeFile(path string) ([]*extensions.Ingress, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ingresses := []*extensions.Ingress{}
+	decoder := yaml.NewYAMLOrJSONDecoder(file, 4096)
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(raw)) == 0 || string(raw) == "null" {
+			continue
+		}
+
+		obj, err := runtime.Decode(api.Codecs.UniversalDecoder(), raw)
+		if err != nil {
+			return nil, err
+		}
+
+		switch obj := obj.(type) {
+		case *extensions.Ingress:
+			ingresses = append(ingresses, s.defaultIngress(obj))
+		case *extensions.IngressList:
+			for i := range obj.Items {
+				ingresses = append(ingresses, s.defaultIngress(&obj.Items[i]))
+			}
+		default:
+			log.Debugf("Skipping %T in '%s'", obj, path)
+		}
+	}
+
+	return ingresses, nil
+}
+
+func (s *ingressFileSource) defaultIngress(ing *extensions.Ingress) *extensions.Ingress {
+	if len(ing.Namespace) == 0 {
+		ing.Namespace = s.defaultNamespace
+	}
+	return ing
+}
+
+// parseBackendOverrides parses 'service:port=host:port' mappings, the service
+// may be qualified as 'namespace/service:port'
+func parseBackendOverrides(overrides []string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, override := range overrides {
+		parts := strings.SplitN(override, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return nil, fmt.Errorf("Invalid backend override '%s', expected 'service:port=host:port'", override)
+		}
+		result[parts[0]] = parts[1]
+	}
+	return result, nil
+}
+
+// backendOverride returns the host:port configured for a backend
+func (c *runtimeConfig) backendOverride(b *ingressBackend) (string, bool) {
+	key := fmt.Sprintf("%s:%s", b.ServiceName, b.ServicePort.String())
+	if host, ok := c.overrides[b.Namespace+"/"+key]; ok {
+		return host, true
+	}
+	host, ok := c.overrides[key]
+	return host, ok
+}
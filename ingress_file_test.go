@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const exampleIngressManifest = `---
+apiVersion: extensions/v1beta1
+kind: Ingress
+metadata:
+  name: ingress1
+spec:
+  backend:
+    serviceName: service1
+    servicePort: 8080
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: service1
+spec:
+  ports:
+  - port: 8080
+---
+apiVersion: extensions/v1beta1
+kind: Ingress
+metadata:
+  name: ingress2
+  namespace: team-a
+spec:
+  rules:
+  - host: www.team-a.de
+    http:
+      paths:
+      - path: /
+        backend:
+          serviceName: service2
+          servicePort: 80
+`
+
+func TestIngressFileSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ingress-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "ingress.yaml"), []byte(exampleIngressManifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	i := NewIngressProxy()
+	reconfigure(t, i, func(c *Config) {
+		c.BackendOverrides = []string{"team-a/service2:80=127.0.0.1:3000"}
+	})
+	if err := newIngressFileSource(i, dir, 0).list(); err != nil {
+		t.Fatalf("reading ingress files failed: %s", err)
+	}
+
+	if len(i.ingresses()) != 2 {
+		t.Fatalf("expected 2 ingresses, got %d", len(i.ingresses()))
+	}
+	if i.ingresses()[0].Namespace != "default" {
+		t.Errorf("ingress without namespace got namespace=%s", i.ingresses()[0].Namespace)
+	}
+
+	r := http.Request{}
+	r.Host = "www.team-a.de"
+	r.URL = &url.URL{Path: "/"}
+	b := i.routeRequestToBackend(&r)
+	if b.ServiceName != "service2" {
+		t.Errorf("request=%+v routed to wrong backend=%+v", r, b)
+	}
+	if u, err := i.urlFromBackend(i.currentConfig(), b); err != nil || u.Host != "127.0.0.1:3000" {
+		t.Errorf("backend=%+v not overridden, url=%s", b, u)
+	}
+}
+
+func TestIngressFileInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		c := NewConfig()
+		c.IngressFileInterval = interval
+		if err := c.validate(); err == nil {
+			t.Errorf("ingress file interval %s accepted", interval)
+		}
+	}
+	if err := NewConfig().validate(); err != nil {
+		t.Errorf("default config rejected: %s", err)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"sort"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// ingressesByPrecedence orders ingresses the way they are consulted when
+// routing: the oldest ingress by creationTimestamp wins, ingresses created at
+// the same time are ordered by namespace/name. Precedence decides between
+// paths of a host matching equally well and between default backends. A
+// path of a rule is shadowed if every request it matches is routed to
+// another path, a default backend if one with higher precedence defines one
+// as well.
+type ingressesByPrecedence []*extensions.Ingress
+
+func (s ingressesByPrecedence) Len() int      { return len(s) }
+func (s ingressesByPrecedence) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s ingressesByPrecedence) Less(i, j int) bool {
+	ti, tj := s[i].CreationTimestamp, s[j].CreationTimestamp
+	if !ti.Equal(tj) {
+		return ti.Before(tj)
+	}
+	return ingressKey(s[i]) < ingressKey(s[j])
+}
+
+// shadowedRule is a path of a rule never used for routing because the
+// requests it matches are served by other paths, ShadowedBy is the ingress
+// serving a request for the path itself
+type shadowedRule struct {
+	Ingress    string `json:"ingress"`
+	Host       string `json:"host"`
+	Path       string `json:"path"`
+	ShadowedBy string `json:"shadowedBy"`
+}
+
+func (r shadowedRule) isDefaultBackend() bool {
+	return len(r.Host) == 0 && len(r.Path) == 0
+}
+
+// findShadowedRules detects the rules of ingresses sorted by precedence
+// which the routing table compiled from them never selects
+func findShadowedRules(ingresses []*extensions.Ingress, t *routingTable) []shadowedRule {
+	shadowed := []shadowedRule{}
+
+	defaultBackend := ""
+	for _, ing := range ingresses {
+		if ing.Spec.Backend == nil {
+			continue
+		}
+		key := ingressKey(ing)
+		if len(defaultBackend) == 0 {
+			defaultBackend = key
+		} else if defaultBackend != key {
+			shadowed = append(shadowed, shadowedRule{Ingress: key, ShadowedBy: defaultBackend})
+		}
+	}
+
+	for _, p := range t.paths {
+		r := p.routes.shadowedBy(p)
+		if r == nil {
+			continue
+		}
+		shadowed = append(shadowed, shadowedRule{
+			Ingress:    ingressKey(p.Ingress),
+			Host:       p.host,
+			Path:       p.Path,
+			ShadowedBy: ingressKey(r.Ingress),
+		})
+	}
+
+	return shadowed
+}
+
+// setShadowedRules stores the shadowed rules and returns the keys of
+// ingresses whose shadowed rules have changed
+func (ip *IngressProxy) setShadowedRules(shadowed []shadowedRule) []string {
+	ip.shadowedRulesLock.Lock()
+	defer ip.shadowedRulesLock.Unlock()
+
+	before := make(map[shadowedRule]bool)
+	for _, rule := range ip.shadowedRules {
+		before[rule] = true
+	}
+	after := make(map[shadowedRule]bool)
+	for _, rule := range shadowed {
+		after[rule] = true
+	}
+
+	changed := make(map[string]bool)
+	for rule := range before {
+		if !after[rule] {
+			changed[rule.Ingress] = true
+		}
+	}
+	for rule := range after {
+		if !before[rule] {
+			changed[rule.Ingress] = true
+		}
+	}
+
+	ip.shadowedRules = shadowed
+
+	keys := make([]string, 0, len(changed))
+	for key := range changed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// getShadowedRules returns the rules shadowed by ingresses with higher
+// precedence
+func (ip *IngressProxy) getShadowedRules() []shadowedRule {
+	ip.shadowedRulesLock.RLock()
+	defer ip.shadowedRulesLock.RUnlock()
+	return ip.shadowedRules
+}
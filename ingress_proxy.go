@@ -1,110 +1,122 @@
 package main
 
 import (
-	"errors"
+	"crypto/tls"
 	"fmt"
-	"io/ioutil"
+	"net"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"os"
-	"path/filepath"
-	"reflect"
+	"os/signal"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"k8s.io/kubernetes/pkg/api"
 	"k8s.io/kubernetes/pkg/apis/extensions"
 	kube "k8s.io/kubernetes/pkg/client/unversioned"
-	"k8s.io/kubernetes/pkg/util"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/util/workqueue"
 )
 
 type IngressProxy struct {
-	IngressName      string
-	IngressNamespace string
-	Ingress          *extensions.Ingress
-	HttpPort         int16
-	HttpsPort        int16
-	kubeClient       *kube.Client
-	ingClient        kube.IngressInterface
-	backends         map[string]*httputil.ReverseProxy
-	backendsLock     sync.RWMutex
-	daemonWaitGroup  sync.WaitGroup
+	IngressName       string
+	IngressNamespaces []string
+	HttpPort          int
+	HttpsPort         int
+	KubeClientConfig  KubeClientConfig
+	config            atomic.Value
+	baseConfig        *Config
+	kubeClient        *kube.Client
+	ingressClient     kube.IngressNamespacer
+	ingressCache      *resourceCache
+	ingressFileSource *ingressFileSource
+	configMapWatcher  *configMapWatcher
+	statusSyncCh      chan struct{}
+	statusLock        sync.Mutex
+	statusCleared     bool
+	publishedStatus   map[string][]api.LoadBalancerIngress
+	leaderElector     *leaderElector
+	eventRecorder     *eventRecorder
+	checkQueue        *workqueue.Type
+	rebuildQueue      *workqueue.Type
+	serviceCache      *resourceCache
+	endpointsCache    *resourceCache
+	secretCache       *resourceCache
+	namespaceCache    *resourceCache
+	rejected          map[string]*extensions.Ingress
+	rejectedLock      sync.Mutex
+	shadowedRules     []shadowedRule
+	shadowedRulesLock sync.RWMutex
+	ingressStore      map[string]*extensions.Ingress
+	ingressStoreLock  sync.Mutex
+	snapshot          atomic.Value
+	snapshotLock      sync.Mutex
+	daemonWaitGroup   sync.WaitGroup
+}
+
+// ingressBackend is a backend together with the namespace and settings of
+// the ingress that defined it and the proxy to reach it
+type ingressBackend struct {
+	*extensions.IngressBackend
+	Namespace string
+	// Matched is the part of the request path matched by the rule's path
+	Matched  string
+	Settings *ingressSettings
+	Proxy    *backendProxy
 }
 
 func NewIngressProxy() *IngressProxy {
-	i := &IngressProxy{
-		HttpPort:  8080,
-		HttpsPort: 8443,
+	i := &IngressProxy{}
+	i.ingressStore = make(map[string]*extensions.Ingress)
+	i.statusSyncCh = make(chan struct{}, 1)
+	i.publishedStatus = make(map[string][]api.LoadBalancerIngress)
+	i.checkQueue = workqueue.New()
+	i.rebuildQueue = workqueue.New()
+	i.rejected = make(map[string]*extensions.Ingress)
+	i.baseConfig = NewConfig()
+	if err := i.applyConfig(NewConfig()); err != nil {
+		panic(err)
 	}
-	i.backends = make(map[string]*httputil.ReverseProxy)
+	i.updateSnapshot(nil)
 	return i
 }
 
-func (ip *IngressProxy) urlFromBackend(b *extensions.IngressBackend) *url.URL {
+// urlFromBackend returns the URL of a backend's service, named ports are
+// resolved through the service
+func (ip *IngressProxy) urlFromBackend(c *runtimeConfig, b *ingressBackend) (*url.URL, error) {
+	if host, ok := c.backendOverride(b); ok {
+		return &url.URL{
+			Host:   host,
+			Scheme: "http",
+		}, nil
+	}
+
+	port, err := ip.resolveServicePort(b.Namespace, b.IngressBackend)
+	if err != nil {
+		return nil, err
+	}
+
 	return &url.URL{
 		Host: fmt.Sprintf(
-			"%s.%s.svc.cluster.local:%d",
+			"%s.%s.svc.%s:%d",
 			b.ServiceName,
-			ip.Ingress.ObjectMeta.Namespace,
-			b.ServicePort.IntVal,
+			b.Namespace,
+			c.ClusterDomain,
+			port,
 		),
 		Scheme: "http",
-	}
+	}, nil
 }
 
-func (ip *IngressProxy) routeRequest(r *http.Request) *httputil.ReverseProxy {
-
-	backend := ip.routeRequestToBackend(r)
-	if backend == nil {
-		return nil
-	}
-
-	backendKey := fmt.Sprintf("%s:%s", backend.ServiceName, backend.ServicePort)
-
-	if backendProxy, ok := ip.backends[backendKey]; ok {
-		return backendProxy
-	}
-
-	backendProxy := httputil.NewSingleHostReverseProxy(
-		ip.urlFromBackend(backend),
-	)
-	ip.backends[backendKey] = backendProxy
-
-	return backendProxy
-}
-
-func (ip *IngressProxy) routeRequestToBackend(r *http.Request) *extensions.IngressBackend {
-
-	for _, rule := range ip.Ingress.Spec.Rules {
-		if strings.ToLower(rule.Host) != strings.ToLower(r.Host) {
-			//skip if hostname does not match
-			continue
-		}
-
-		matchingLen := 0
-		matchingBackend := -1
-
-		for pos, path := range rule.HTTP.Paths {
-			fullPath := r.URL.Path
-			prefixPath := path.Path
-			if prefixPath == "" {
-				prefixPath = "/"
-			}
-			if strings.HasPrefix(fullPath, prefixPath) && len(prefixPath) > matchingLen {
-				matchingLen = len(path.Path)
-				matchingBackend = pos
-			}
-		}
-
-		if matchingBackend != -1 {
-			return &rule.HTTP.Paths[matchingBackend].Backend
-		}
-
-	}
-
-	return ip.Ingress.Spec.Backend
+// routeRequestToBackend looks up the backend for a request in the current
+// snapshot
+func (ip *IngressProxy) routeRequestToBackend(r *http.Request) *ingressBackend {
+	return ip.currentSnapshot().routeRequestToBackend(r)
 }
 
 func (ip *IngressProxy) httpError(w http.ResponseWriter, msg string, code int) {
@@ -116,99 +128,238 @@ func (ip *IngressProxy) handle(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("X-KubeIngressProxy", "go alter!")
 	log.Infof("host=%s path=%s method=%s", r.Host, r.URL.Path, r.Method)
 
-	proxy := ip.routeRequest(r)
-	if proxy == nil {
+	if !ip.ready() {
+		ip.httpError(w, "Not ready", 503)
+		return
+	}
+
+	if headerBytes(r) > ip.currentConfig().MaxHeaderBytes {
+		ip.httpError(w, "Request header too large", 431)
+		return
+	}
+
+	s := ip.currentSnapshot()
+	backend := s.routeRequestToBackend(r)
+	if backend == nil {
 		ip.httpError(w, "No backend found", 503)
 		return
 	}
-	proxy.ServeHTTP(w, r)
-}
 
-func (ip *IngressProxy) getKubeClient() (*kube.Client, error) {
-	return kube.NewInCluster()
+	if backend.Settings.handleCORS(w, r) {
+		return
+	}
+	if !ip.authorize(s, w, r, backend) {
+		return
+	}
+	backend.Settings.rewritePath(r, backend.Matched)
+
+	if backend.Proxy == nil {
+		ip.httpError(w, "Backend not available", 503)
+		return
+	}
+	backend.Proxy.ServeHTTP(w, r)
 }
 
-func (ip *IngressProxy) getConfig() {
+// getConfig loads the effective config from defaults, config file,
+// environment and command line
+func (ip *IngressProxy) getConfig() error {
+	config, err := LoadConfig(os.Args[1:])
+	if err != nil {
+		return err
+	}
+
+	config.setupLogging()
+	log.Infof("Effective configuration:")
+	for _, line := range strings.Split(strings.TrimSpace(config.String()), "\n") {
+		log.Infof("  %s", line)
+	}
 
+	ip.baseConfig = config
+	return ip.applyConfig(config)
 }
 
-func (ip *IngressProxy) readEnv() error {
+// runtimeConfig is a config together with the state derived from it. It is
+// never changed once swapped in, a reload swaps in a new one, so it can be
+// used from any goroutine.
+type runtimeConfig struct {
+	*Config
+	selector  labels.Selector
+	overrides map[string]string
+	tls       *tls.Config
+}
 
-	ip.IngressName = os.Getenv("INGRESS_NAME")
-	if len(ip.IngressName) == 0 {
-		return errors.New("Please provide an ingress resource name in env var INGRESS_NAME")
-	}
+// currentConfig returns the config currently applied
+func (ip *IngressProxy) currentConfig() *runtimeConfig {
+	return ip.config.Load().(*runtimeConfig)
+}
 
-	ip.IngressNamespace = os.Getenv("INGRESS_NAMESPACE")
-	if len(ip.IngressNamespace) == 0 {
-		ip.IngressNamespace = api.NamespaceDefault
+// applyConfig sets up the proxy with the config it is started with, the
+// servers, watched namespaces and API server connection are never changed
+// afterwards
+func (ip *IngressProxy) applyConfig(c *Config) error {
+	if err := ip.swapConfig(c); err != nil {
+		return err
 	}
-
+	ip.IngressName = c.IngressName
+	ip.IngressNamespaces = c.namespaces()
+	ip.HttpPort = c.HttpPort
+	ip.HttpsPort = c.HttpsPort
+	ip.KubeClientConfig = c.Kube
 	return nil
 }
 
-func (ip *IngressProxy) Init() error {
-
-	err := ip.readEnv()
+// swapConfig derives the runtime config from a config and swaps it in
+func (ip *IngressProxy) swapConfig(c *Config) error {
+	selector, err := labels.Parse(c.IngressSelector)
 	if err != nil {
 		return err
 	}
+	if selector.Empty() {
+		selector = nil
+	}
 
-	kubeClient, err := kube.NewInCluster()
+	tlsConfig, err := c.tlsConfig()
 	if err != nil {
 		return err
 	}
-	ip.kubeClient = kubeClient
 
-	ip.ingClient = ip.kubeClient.Extensions().Ingress(ip.IngressNamespace)
-
-	ingress, err := ip.ingClient.Get(ip.IngressName)
+	overrides, err := parseBackendOverrides(c.BackendOverrides)
 	if err != nil {
 		return err
 	}
-	ip.SetIngress(ingress)
 
+	ip.config.Store(&runtimeConfig{
+		Config:    c,
+		selector:  selector,
+		overrides: overrides,
+		tls:       tlsConfig,
+	})
 	return nil
 }
 
-func (ip *IngressProxy) SetIngress(ing *extensions.Ingress) {
-	// TODO: Use read write lock for that
-	ip.Ingress = ing
+// newTransport creates the transport to talk to backends, waiting for
+// response headers at most for the upstream timeout
+func newTransport(c *Config, upstreamTimeout time.Duration) *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		Dial: (&net.Dialer{
+			Timeout:   c.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).Dial,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: upstreamTimeout,
+	}
 }
 
-func (ip *IngressProxy) WatchConfig() {
+func (ip *IngressProxy) Init() error {
+
+	err := ip.getConfig()
+	if err != nil {
+		return err
+	}
+
+	c := ip.baseConfig
+	if len(c.ConfigMap) > 0 && len(c.IngressFile) > 0 {
+		log.Warnf("Ignoring ConfigMap %s, it can't be read without API server", c.ConfigMap)
+	}
 
-	rateLimiter := util.NewTokenBucketRateLimiter(0.1, 1)
+	if len(c.IngressFile) > 0 {
+		log.Infof("Reading ingresses from '%s', not connecting to the API server", c.IngressFile)
+		ip.ingressFileSource = newIngressFileSource(ip, c.IngressFile, c.IngressFileInterval)
+		return ip.ingressFileSource.list()
+	}
 
-	for {
-		rateLimiter.Accept()
+	kubeClient, err := ip.getKubeClient()
+	if err != nil {
+		return err
+	}
+	ip.kubeClient = kubeClient
+	ip.ingressClient = kubeClient.Extensions()
+	ip.eventRecorder = newEventRecorder(kubeClient)
 
-		ingress, err := ip.ingClient.Get(ip.IngressName)
+	if len(c.ConfigMap) > 0 {
+		w, err := newConfigMapWatcher(ip, c.ConfigMap)
 		if err != nil {
-			log.Warnf("Getting config failed: %s", err)
+			return err
+		}
+		if err := w.cache.sync(); err != nil {
+			return err
 		}
+		ip.configMapWatcher = w
+	}
 
-		if reflect.DeepEqual(ip.Ingress, ingress) {
-			continue
+	if c.LeaderElect {
+		lock, err := newResourceLock(kubeClient, c.LeaderElectResource, c.LeaderElectNamespace, c.LeaderElectName)
+		if err != nil {
+			return err
 		}
+		ip.leaderElector = newLeaderElector(lock, leaderElectionIdentity(), c)
+	}
 
-		log.Infof("Upgrade ingress config")
-		ip.SetIngress(ingress)
+	if err := ip.setupNamespaceCache(); err != nil {
+		return err
 	}
+	ip.setupCaches()
+	if err := ip.syncCaches(); err != nil {
+		return err
+	}
+
+	ip.setupIngressCache(ip.ingressClient)
+	return ip.ingressCache.sync()
+}
 
+// SetIngresses replaces the ingresses used for routing, ordered by their
+// precedence
+func (ip *IngressProxy) SetIngresses(ingresses []*extensions.Ingress) {
+	sort.Sort(ingressesByPrecedence(ingresses))
+	previous := ip.ingresses()
+	s := ip.updateSnapshot(ingresses)
+
+	// report ingresses which started or stopped being shadowed
+	for _, key := range ip.setShadowedRules(findShadowedRules(ingresses, s.routes)) {
+		ip.checkQueue.Add(key)
+	}
+
+	// the status is published for the served ingresses, it only needs to be
+	// synced right away if they changed
+	if !sameIngressKeys(previous, ingresses) {
+		ip.triggerStatusSync()
+	}
+}
+
+// sameIngressKeys checks if two lists contain the same ingresses, in any
+// version
+func sameIngressKeys(a, b []*extensions.Ingress) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	keys := make(map[string]bool, len(a))
+	for _, ing := range a {
+		keys[ingressKey(ing)] = true
+	}
+	for _, ing := range b {
+		if !keys[ingressKey(ing)] {
+			return false
+		}
+	}
+	return true
 }
 
 func (ip *IngressProxy) Start() {
 
 	http.HandleFunc("/", ip.handle)
 
-
 	// http server port
 	ip.daemonWaitGroup.Add(1)
 	go func() {
 		defer ip.daemonWaitGroup.Done()
 		log.Infof("Start listening for HTTP on port %d", ip.HttpPort)
-		err := http.ListenAndServe(fmt.Sprintf(":%d", ip.HttpPort), nil)
+		l, err := newTimeoutListener(ip, ip.HttpPort, nil)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		err = ip.server(l).Serve(l)
 		log.Error(err)
 	}()
 
@@ -216,42 +367,31 @@ func (ip *IngressProxy) Start() {
 	ip.daemonWaitGroup.Add(1)
 	go func() {
 		defer ip.daemonWaitGroup.Done()
-		// getting secrets tls
-		secretClient := ip.kubeClient.Secrets(ip.IngressNamespace)
-		secretName := ip.Ingress.Spec.TLS[0].SecretName
-		secret, err := secretClient.Get(secretName)
-		if err != nil {
-			log.Errorf("TLS secret '%s' not found: %s", secretName, err)
-			return
-		}
-
-		// hacky approach TODO: do this in memory
-		dir, err := ioutil.TempDir("", "example")
+		log.Infof("Start listening for HTTPS on port %d", ip.HttpsPort)
+		// the certificates and TLS settings are taken from the current
+		// snapshot per connection, so reloads apply and secrets added later
+		// are served
+		l, err := newTimeoutListener(ip, ip.HttpsPort, &tls.Config{
+			GetConfigForClient: ip.getConfigForClient,
+			GetCertificate:     ip.getCertificate,
+		})
 		if err != nil {
 			log.Error(err)
 			return
 		}
-
-		defer os.RemoveAll(dir) // clean up
-
-		certPath := filepath.Join(dir, "cert.pem")
-		keyPath := filepath.Join(dir, "key.pem")
-
-		if err := ioutil.WriteFile(certPath, secret.Data[api.TLSCertKey], 0644); err != nil {
-			log.Error(err)
-			return
-		}
-		if err := ioutil.WriteFile(keyPath, secret.Data[api.TLSPrivateKeyKey], 0600); err != nil {
-			log.Error(err)
-			return
-		}
-		log.Infof("Start listening for HTTPS on port %d", ip.HttpsPort)
-		err = http.ListenAndServeTLS(fmt.Sprintf(":%d", ip.HttpsPort), certPath, keyPath, nil)
+		err = ip.server(l).Serve(l)
 		log.Error(err)
-
-		http.ListenAndServe(fmt.Sprintf(":%d", ip.HttpPort), nil)
 	}()
 
+	// services, endpoints and secrets
+	ip.runCaches()
+	go ip.runRebuilds()
+
+	// global defaults watcher
+	if ip.configMapWatcher != nil {
+		ip.configMapWatcher.cache.run()
+	}
+
 	// config watcher
 	ip.daemonWaitGroup.Add(1)
 	go func() {
@@ -259,5 +399,58 @@ func (ip *IngressProxy) Start() {
 		ip.WatchConfig()
 	}()
 
+	// ingress problems reported as events
+	if ip.eventRecorder != nil {
+		go ip.eventRecorder.run()
+	}
+	go ip.runIngressChecks()
+
+	// health and metrics
+	ip.daemonWaitGroup.Add(1)
+	go func() {
+		defer ip.daemonWaitGroup.Done()
+		ip.serveAdmin()
+	}()
+
+	// cluster-mutating work, only done by the leader
+	if ip.leaderElector != nil {
+		ip.leaderElector.onStartedLeading = ip.runLeaderWork
+		ip.daemonWaitGroup.Add(1)
+		go func() {
+			defer ip.daemonWaitGroup.Done()
+			ip.leaderElector.run()
+		}()
+	} else {
+		ip.daemonWaitGroup.Add(1)
+		go func() {
+			defer ip.daemonWaitGroup.Done()
+			ip.runLeaderWork(nil)
+		}()
+	}
+
+	go ip.handleSignals()
+
 	ip.daemonWaitGroup.Wait()
 }
+
+// handleSignals cleans up before the proxy terminates
+func (ip *IngressProxy) handleSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	sig := <-signals
+	log.Infof("Received %s, shutting down", sig)
+
+	if ip.publishesStatus() && ip.isLeader() {
+		ip.clearStatus()
+	}
+
+	os.Exit(0)
+}
+
+// runLeaderWork runs all singleton work until stop is closed
+func (ip *IngressProxy) runLeaderWork(stop <-chan struct{}) {
+	if ip.publishesStatus() {
+		ip.runStatusSync(stop)
+	}
+}
@@ -3,9 +3,12 @@ package main
 import (
 	"net/http"
 	"net/url"
+	"reflect"
 	"testing"
+	"time"
 
 	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
 	"k8s.io/kubernetes/pkg/apis/extensions"
 	"k8s.io/kubernetes/pkg/util/intstr"
 )
@@ -45,6 +48,315 @@ func TestSampleConfigRouting(t *testing.T) {
 		t.Errorf("request=%+v routed to wrong backend=%+v", r, b)
 	}
 
+	// wildcard hosts in a second ingress
+	wildcard := &extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "ingress2",
+			Namespace: "default",
+		},
+		Spec: extensions.IngressSpec{
+			Rules: []extensions.IngressRule{
+				exampleRule("*.test.de", "service6"),
+				exampleRule("*.shop.test.de", "service7"),
+				exampleRule("bücher.test.de", "service8"),
+			},
+		},
+	}
+	i.SetIngresses(append(i.ingresses(), wildcard))
+
+	for _, test := range []struct {
+		host    string
+		service string
+	}{
+		// exact beats wildcard
+		{"www.test.de", "service2"},
+		// ports, trailing dots and case are ignored
+		{"www.test.de:8080", "service2"},
+		{"WWW.Test.DE.", "service2"},
+		{"www.test.de.:443", "service2"},
+		// wildcards match subdomains, longer suffix wins
+		{"customer1.test.de", "service6"},
+		{"a.customer1.test.de", "service6"},
+		{"a.shop.test.de", "service7"},
+		{"a.b.shop.test.de:80", "service7"},
+		{"shop.test.de", "service6"},
+		// the wildcard doesn't match the domain itself
+		{"test.de", "service1"},
+		// internationalized hosts match in unicode and punycode form
+		{"bücher.test.de", "service8"},
+		{"BÜCHER.test.de", "service8"},
+		{"xn--bcher-kva.test.de", "service8"},
+	} {
+		r = http.Request{}
+		r.Host = test.host
+		r.URL = &url.URL{Path: "/any/page/asd"}
+		b = i.routeRequestToBackend(&r)
+		if b == nil || b.ServiceName != test.service {
+			t.Errorf("request for host=%s routed to wrong backend=%+v, expected %s", test.host, b, test.service)
+		}
+	}
+}
+
+func exampleRule(host, service string) extensions.IngressRule {
+	return extensions.IngressRule{
+		Host: host,
+		IngressRuleValue: extensions.IngressRuleValue{
+			HTTP: &extensions.HTTPIngressRuleValue{
+				Paths: []extensions.HTTPIngressPath{
+					extensions.HTTPIngressPath{
+						Path: "/",
+						Backend: extensions.IngressBackend{
+							ServiceName: service,
+							ServicePort: intstr.FromInt(8080),
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestMultipleIngressRouting(t *testing.T) {
+	i := exampleIngress()
+
+	other := &extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "ingress2",
+			Namespace: "team-a",
+		},
+		Spec: extensions.IngressSpec{
+			Rules: []extensions.IngressRule{
+				extensions.IngressRule{
+					Host: "www.team-a.de",
+					IngressRuleValue: extensions.IngressRuleValue{
+						HTTP: &extensions.HTTPIngressRuleValue{
+							Paths: []extensions.HTTPIngressPath{
+								extensions.HTTPIngressPath{
+									Path: "/",
+									Backend: extensions.IngressBackend{
+										ServiceName: "service1",
+										ServicePort: intstr.FromInt(8080),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	i.SetIngresses(append(i.ingresses(), other))
+
+	r := http.Request{}
+	r.Host = "www.team-a.de"
+	r.URL = &url.URL{Path: "/any/page/asd"}
+	b := i.routeRequestToBackend(&r)
+	if b.ServiceName != "service1" || b.Namespace != "team-a" {
+		t.Errorf("request=%+v routed to wrong backend=%+v", r, b)
+	}
+	if u, err := i.urlFromBackend(i.currentConfig(), b); err != nil || u.Host != "service1.team-a.svc.cluster.local:8080" {
+		t.Errorf("backend=%+v resolved to wrong url=%s", b, u)
+	}
+
+	r = http.Request{}
+	r.Host = "www.unknown.de"
+	r.URL = &url.URL{Path: "/any/page/asd"}
+	b = i.routeRequestToBackend(&r)
+	if b.ServiceName != "service1" || b.Namespace != "default" {
+		t.Errorf("request=%+v routed to wrong backend=%+v", r, b)
+	}
+}
+
+func TestIngressPrecedence(t *testing.T) {
+	i := exampleIngress()
+	i.ingresses()[0].CreationTimestamp = unversioned.NewTime(time.Unix(2000, 0))
+
+	older := &extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{
+			Name:              "ingress2",
+			Namespace:         "team-a",
+			CreationTimestamp: unversioned.NewTime(time.Unix(1000, 0)),
+		},
+		Spec: extensions.IngressSpec{
+			Rules: []extensions.IngressRule{
+				extensions.IngressRule{
+					Host: "www.test.de",
+					IngressRuleValue: extensions.IngressRuleValue{
+						HTTP: &extensions.HTTPIngressRuleValue{
+							Paths: []extensions.HTTPIngressPath{
+								extensions.HTTPIngressPath{
+									Path: "/",
+									Backend: extensions.IngressBackend{
+										ServiceName: "service6",
+										ServicePort: intstr.FromInt(8080),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	i.SetIngresses(append(i.ingresses(), older))
+
+	r := http.Request{}
+	r.Host = "www.test.de"
+	r.URL = &url.URL{Path: "/"}
+	b := i.routeRequestToBackend(&r)
+	if b.ServiceName != "service6" || b.Namespace != "team-a" {
+		t.Errorf("request=%+v should be routed by the older ingress, got backend=%+v", r, b)
+	}
+
+	shadowed := i.getShadowedRules()
+	expected := shadowedRule{Ingress: "default/ingress1", Host: "www.test.de", Path: "/", ShadowedBy: "team-a/ingress2"}
+	if len(shadowed) != 1 || shadowed[0] != expected {
+		t.Errorf("expected shadowed rule %+v, got %+v", expected, shadowed)
+	}
+}
+
+func TestShadowedRulesFollowRouting(t *testing.T) {
+	ingress := func(name string, created int64, paths ...string) *extensions.Ingress {
+		rule := exampleRule("www.test.de", name)
+		rule.HTTP.Paths[0].Path = paths[0]
+		for _, path := range paths[1:] {
+			rule.HTTP.Paths = append(rule.HTTP.Paths, extensions.HTTPIngressPath{Path: path, Backend: rule.HTTP.Paths[0].Backend})
+		}
+		return &extensions.Ingress{
+			ObjectMeta: api.ObjectMeta{Name: name, Namespace: "default", CreationTimestamp: unversioned.NewTime(time.Unix(created, 0))},
+			Spec:       extensions.IngressSpec{Rules: []extensions.IngressRule{rule}},
+		}
+	}
+
+	// '/api' of a newer ingress is longer than '/' of an older one
+	i := NewIngressProxy()
+	i.SetIngresses([]*extensions.Ingress{ingress("newer", 2000, "/api"), ingress("older", 1000, "/")})
+	if shadowed := i.getShadowedRules(); len(shadowed) != 0 {
+		t.Errorf("expected no shadowed rules, got %+v", shadowed)
+	}
+
+	// a path matching only requests a path of an older ingress matches as
+	// well is shadowed, even if written differently
+	second := ingress("second", 2000, "/api/", "/api/v1")
+	second.Annotations = map[string]string{annotationPrefix + "path-type": pathTypePrefix}
+	i.SetIngresses([]*extensions.Ingress{ingress("first", 1000, "/api"), second, ingress("third", 3000, "/")})
+	expected := []shadowedRule{
+		{Ingress: "default/second", Host: "www.test.de", Path: "/api/", ShadowedBy: "default/first"},
+	}
+	if shadowed := i.getShadowedRules(); !reflect.DeepEqual(shadowed, expected) {
+		t.Errorf("expected shadowed rules %+v, got %+v", expected, shadowed)
+	}
+}
+
+func TestRegexPathRouting(t *testing.T) {
+	i := NewIngressProxy()
+
+	rule := exampleRule("api.test.de", "service1")
+	rule.HTTP.Paths = append(rule.HTTP.Paths,
+		extensions.HTTPIngressPath{
+			Path: "/api/v[0-9]+/",
+			Backend: extensions.IngressBackend{
+				ServiceName: "service2",
+				ServicePort: intstr.FromInt(8080),
+			},
+		},
+		extensions.HTTPIngressPath{
+			Path: "/api/v[0-9]+/users(/.*)?",
+			Backend: extensions.IngressBackend{
+				ServiceName: "service3",
+				ServicePort: intstr.FromInt(8080),
+			},
+		},
+	)
+	ing := &extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{
+			Name:        "ingress1",
+			Namespace:   "default",
+			Annotations: map[string]string{annotationPrefix + "use-regex": "true"},
+		},
+		Spec: extensions.IngressSpec{
+			Rules: []extensions.IngressRule{rule},
+		},
+	}
+	i.SetIngresses([]*extensions.Ingress{ing})
+
+	for _, test := range []struct {
+		path    string
+		service string
+	}{
+		{"/", "service1"},
+		{"/api/", "service1"},
+		{"/api/v1/", "service2"},
+		{"/api/v12/groups", "service2"},
+		{"/api/v2/users", "service3"},
+		{"/api/v2/users/42", "service3"},
+		{"/api/vx/users", "service1"},
+	} {
+		r := http.Request{}
+		r.Host = "api.test.de"
+		r.URL = &url.URL{Path: test.path}
+		b := i.routeRequestToBackend(&r)
+		if b == nil || b.ServiceName != test.service {
+			t.Errorf("request for path=%s routed to wrong backend=%+v, expected %s", test.path, b, test.service)
+		}
+	}
+
+	ing.Spec.Rules[0].HTTP.Paths[1].Path = "/api/v[0-9/"
+	if problems := i.validateIngress(ing); len(problems) != 1 || problems[0].Reason != reasonInvalidPath {
+		t.Errorf("expected an invalid path, got %v", problems)
+	}
+}
+
+func TestClaimsIngress(t *testing.T) {
+	ing := &extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{
+			Name:        "ingress1",
+			Namespace:   "default",
+			Labels:      map[string]string{"team": "a"},
+			Annotations: map[string]string{ingressClassAnnotation: "gce"},
+		},
+	}
+
+	i := NewIngressProxy()
+	if claimed, _ := i.claimsIngress(ing); !claimed {
+		t.Errorf("ingress=%+v should be claimed without class", ing)
+	}
+
+	reconfigure(t, i, func(c *Config) {
+		c.IngressClass = "kube-ingress-proxy"
+	})
+	if claimed, _ := i.claimsIngress(ing); claimed {
+		t.Errorf("ingress=%+v of other class should not be claimed", ing)
+	}
+
+	delete(ing.Annotations, ingressClassAnnotation)
+	if claimed, _ := i.claimsIngress(ing); !claimed {
+		t.Errorf("ingress=%+v without class should be claimed", ing)
+	}
+
+	reconfigure(t, i, func(c *Config) {
+		c.IngressClassRequired = true
+	})
+	if claimed, _ := i.claimsIngress(ing); claimed {
+		t.Errorf("ingress=%+v without class should not be claimed", ing)
+	}
+
+	ing.Annotations[ingressClassAnnotation] = "kube-ingress-proxy"
+	reconfigure(t, i, func(c *Config) {
+		c.IngressSelector = "team=b"
+	})
+	if claimed, _ := i.claimsIngress(ing); claimed {
+		t.Errorf("ingress=%+v with other labels should not be claimed", ing)
+	}
+}
+
+// reconfigure reloads the proxy with a changed copy of its config
+func reconfigure(t *testing.T, ip *IngressProxy, change func(c *Config)) {
+	c := *ip.currentConfig().Config
+	change(&c)
+	if err := ip.reloadConfig(&c); err != nil {
+		t.Fatal(err)
+	}
 }
 
 func exampleIngress() *IngressProxy {
@@ -119,7 +431,7 @@ func exampleIngress() *IngressProxy {
 	}
 
 	ip := NewIngressProxy()
-	ip.Ingress = config
+	ip.SetIngresses([]*extensions.Ingress{config})
 
 	return ip
 }
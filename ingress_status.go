@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"k8s.io/kubernetes/pkg/api"
+	apierrors "k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+const statusSyncInterval = 30 * time.Second
+
+// publishesStatus is true if addresses are configured to be written into
+// the status of the served ingresses
+func (ip *IngressProxy) publishesStatus() bool {
+	c := ip.currentConfig()
+	return ip.ingressClient != nil && (len(c.PublishAddresses) > 0 || len(c.PublishService) > 0)
+}
+
+// statusAddresses returns the configured addresses and the ones of the
+// publish service
+func (ip *IngressProxy) statusAddresses() ([]api.LoadBalancerIngress, error) {
+	c := ip.currentConfig()
+	addresses := []api.LoadBalancerIngress{}
+
+	for _, address := range c.PublishAddresses {
+		if net.ParseIP(address) != nil {
+			addresses = append(addresses, api.LoadBalancerIngress{IP: address})
+		} else {
+			addresses = append(addresses, api.LoadBalancerIngress{Hostname: address})
+		}
+	}
+
+	if len(c.PublishService) > 0 {
+		namespace, name, err := parsePublishService(c.PublishService)
+		if err != nil {
+			return nil, err
+		}
+
+		svc, err := ip.kubeClient.Services(namespace).Get(name)
+		if err != nil {
+			return nil, fmt.Errorf("Error getting publish service '%s': %s", c.PublishService, err)
+		}
+
+		addresses = append(addresses, svc.Status.LoadBalancer.Ingress...)
+		for _, externalIP := range svc.Spec.ExternalIPs {
+			addresses = append(addresses, api.LoadBalancerIngress{IP: externalIP})
+		}
+	}
+
+	sort.Sort(loadBalancerIngressByAddress(addresses))
+	return addresses, nil
+}
+
+func parsePublishService(service string) (string, string, error) {
+	parts := strings.Split(service, "/")
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return "", "", fmt.Errorf("Invalid publish service '%s', expected 'namespace/name'", service)
+	}
+	return parts[0], parts[1], nil
+}
+
+// loadBalancerIngressByAddress sorts addresses, so they can be compared
+type loadBalancerIngressByAddress []api.LoadBalancerIngress
+
+func (s loadBalancerIngressByAddress) Len() int      { return len(s) }
+func (s loadBalancerIngressByAddress) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s loadBalancerIngressByAddress) Less(i, j int) bool {
+	return s[i].IP+"/"+s[i].Hostname < s[j].IP+"/"+s[j].Hostname
+}
+
+// runStatusSync publishes the addresses periodically and whenever the served
+// ingresses change, until stop is closed
+func (ip *IngressProxy) runStatusSync(stop <-chan struct{}) {
+	ticker := time.NewTicker(statusSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		ip.syncStatus()
+
+		select {
+		case <-ticker.C:
+		case <-ip.statusSyncCh:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// triggerStatusSync requests a status sync without blocking
+func (ip *IngressProxy) triggerStatusSync() {
+	select {
+	case ip.statusSyncCh <- struct{}{}:
+	default:
+	}
+}
+
+func (ip *IngressProxy) syncStatus() {
+	ip.statusLock.Lock()
+	defer ip.statusLock.Unlock()
+
+	if ip.statusCleared || !ip.isLeader() {
+		return
+	}
+
+	addresses, err := ip.statusAddresses()
+	if err != nil {
+		log.Warnf("Not updating ingress status: %s", err)
+		return
+	}
+
+	served := make(map[string]bool)
+	for _, ing := range ip.ingresses() {
+		key := ingressKey(ing)
+		served[key] = true
+		if err := ip.publishStatus(ing.Namespace, ing.Name, addresses); err != nil {
+			log.Warnf("Updating status of ingress %s failed: %s", key, err)
+		}
+	}
+
+	// ingresses may leave the served set by being deleted, rejected after a
+	// restart, claimed by another class or through their namespace
+	for key := range ip.publishedStatus {
+		if !served[key] {
+			ip.withdrawStatus(key)
+		}
+	}
+}
+
+// clearStatus removes the published addresses from all ingresses
+func (ip *IngressProxy) clearStatus() {
+	ip.statusLock.Lock()
+	defer ip.statusLock.Unlock()
+
+	// no more syncs after shutdown
+	ip.statusCleared = true
+
+	for _, ing := range ip.ingresses() {
+		if err := ip.publishStatus(ing.Namespace, ing.Name, []api.LoadBalancerIngress{}); err != nil {
+			log.Warnf("Clearing status of ingress %s failed: %s", ingressKey(ing), err)
+		}
+		delete(ip.publishedStatus, ingressKey(ing))
+	}
+	for key := range ip.publishedStatus {
+		ip.withdrawStatus(key)
+	}
+}
+
+// publishStatus updates the status of the latest version of an ingress, the
+// status lock has to be held by the caller
+func (ip *IngressProxy) publishStatus(namespace, name string, addresses []api.LoadBalancerIngress) error {
+	ing, err := ip.latestIngress(namespace, name)
+	if err != nil {
+		return err
+	}
+	if err := ip.updateStatus(ing, addresses); err != nil {
+		return err
+	}
+	ip.publishedStatus[ingressKey(ing)] = addresses
+	return nil
+}
+
+// withdrawStatus clears the addresses published to an ingress, unless they
+// have been changed by someone else since. The status lock has to be held by
+// the caller.
+func (ip *IngressProxy) withdrawStatus(key string) {
+	parts := strings.SplitN(key, "/", 2)
+	ing, err := ip.latestIngress(parts[0], parts[1])
+	if apierrors.IsNotFound(err) {
+		delete(ip.publishedStatus, key)
+		return
+	} else if err != nil {
+		log.Warnf("Clearing status of ingress %s failed: %s", key, err)
+		return
+	}
+
+	if !reflect.DeepEqual(ing.Status.LoadBalancer.Ingress, ip.publishedStatus[key]) {
+		delete(ip.publishedStatus, key)
+		return
+	}
+	if err := ip.updateStatus(ing, []api.LoadBalancerIngress{}); err != nil && !apierrors.IsNotFound(err) {
+		log.Warnf("Clearing status of ingress %s failed: %s", key, err)
+		return
+	}
+	delete(ip.publishedStatus, key)
+}
+
+// latestIngress returns the latest known version of an ingress, which may be
+// newer than the served one if it has been rejected. Status updates of older
+// versions would conflict.
+func (ip *IngressProxy) latestIngress(namespace, name string) (*extensions.Ingress, error) {
+	if ip.ingressCache != nil {
+		if obj, ok := ip.ingressCache.get(namespace, name); ok {
+			return obj.(*extensions.Ingress), nil
+		}
+	}
+	return ip.ingressClient.Ingress(namespace).Get(name)
+}
+
+func (ip *IngressProxy) updateStatus(ing *extensions.Ingress, addresses []api.LoadBalancerIngress) error {
+	current := ing.Status.LoadBalancer.Ingress
+	if len(current) == 0 && len(addresses) == 0 {
+		return nil
+	}
+	if reflect.DeepEqual(current, addresses) {
+		return nil
+	}
+
+	updated := *ing
+	updated.Status.LoadBalancer.Ingress = addresses
+
+	log.Infof("Updating status of ingress %s to %v", ingressKey(ing), addresses)
+	_, err := ip.ingressClient.Ingress(ing.Namespace).UpdateStatus(&updated)
+	return err
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+	apierrors "k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	kube "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/runtime"
+)
+
+// statusIngresses keeps ingresses by name like the API server, status
+// updates of outdated versions conflict
+type statusIngresses struct {
+	kube.IngressInterface
+	items   map[string]*extensions.Ingress
+	updates int
+}
+
+func (f *statusIngresses) Ingress(namespace string) kube.IngressInterface {
+	return f
+}
+
+func (f *statusIngresses) Get(name string) (*extensions.Ingress, error) {
+	ing, ok := f.items[name]
+	if !ok {
+		return nil, apierrors.NewNotFound(extensions.Resource("ingresses"), name)
+	}
+	copied := *ing
+	return &copied, nil
+}
+
+func (f *statusIngresses) UpdateStatus(ing *extensions.Ingress) (*extensions.Ingress, error) {
+	current, ok := f.items[ing.Name]
+	if !ok {
+		return nil, apierrors.NewNotFound(extensions.Resource("ingresses"), ing.Name)
+	}
+	if current.ResourceVersion != ing.ResourceVersion {
+		return nil, apierrors.NewConflict(extensions.Resource("ingresses"), ing.Name, errors.New("the object has been modified"))
+	}
+	updated := *current
+	updated.Status = ing.Status
+	version, _ := strconv.Atoi(current.ResourceVersion)
+	updated.ResourceVersion = strconv.Itoa(version + 1)
+	f.items[ing.Name] = &updated
+	f.updates++
+	return &updated, nil
+}
+
+func (f *statusIngresses) status(name string) []api.LoadBalancerIngress {
+	return f.items[name].Status.LoadBalancer.Ingress
+}
+
+func setupStatusIngresses(t *testing.T) (*IngressProxy, *statusIngresses, *extensions.Ingress) {
+	ip := exampleIngress()
+	ing := watchedIngress(t, ip, "1", "service1")
+	reconfigure(t, ip, func(c *Config) {
+		c.PublishAddresses = []string{"10.0.0.1"}
+	})
+	client := &statusIngresses{items: make(map[string]*extensions.Ingress)}
+	ip.ingressClient = client
+
+	ip.storeIngress(ing)
+	if !ip.publishesStatus() {
+		t.Fatal("expected the status to be published")
+	}
+	return ip, client, ing
+}
+
+var publishedAddresses = []api.LoadBalancerIngress{{IP: "10.0.0.1"}}
+
+func TestStatusOfRejectedIngress(t *testing.T) {
+	ip, client, ing := setupStatusIngresses(t)
+
+	// a newer version was rejected, the served one is outdated
+	rejected := *ing
+	rejected.ResourceVersion = "2"
+	client.items[ing.Name] = &rejected
+	ip.ingressCache = newResourceCache("ingresses", []string{api.NamespaceAll},
+		resourceSource{
+			func(namespace string, opts api.ListOptions) ([]runtime.Object, string, error) {
+				return []runtime.Object{&rejected}, "2", nil
+			},
+			nil,
+		},
+		onAnyChange(func() {}),
+	)
+	if err := ip.ingressCache.sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	ip.syncStatus()
+	if client.updates != 1 || !reflect.DeepEqual(client.status(ing.Name), publishedAddresses) {
+		t.Fatalf("expected the status of the latest version to be updated, got %v", client.status(ing.Name))
+	}
+}
+
+func TestStatusOfIngressNoLongerServed(t *testing.T) {
+	ip, client, ing := setupStatusIngresses(t)
+	client.items[ing.Name] = ing
+
+	ip.syncStatus()
+	if !reflect.DeepEqual(client.status(ing.Name), publishedAddresses) {
+		t.Fatalf("expected the addresses to be published, got %v", client.status(ing.Name))
+	}
+	ip.syncStatus()
+	if client.updates != 1 {
+		t.Errorf("unchanged status should not be updated again")
+	}
+
+	ip.deleteIngress(ing)
+	ip.syncStatus()
+	if status := client.status(ing.Name); len(status) != 0 {
+		t.Errorf("expected the status of an ingress no longer served to be cleared, got %v", status)
+	}
+	if len(ip.publishedStatus) != 0 {
+		t.Errorf("cleared status should be forgotten, got %v", ip.publishedStatus)
+	}
+}
+
+func TestStatusChangedByOthers(t *testing.T) {
+	ip, client, ing := setupStatusIngresses(t)
+	client.items[ing.Name] = ing
+
+	ip.syncStatus()
+	other := *client.items[ing.Name]
+	other.Status.LoadBalancer.Ingress = []api.LoadBalancerIngress{{IP: "10.0.0.2"}}
+	client.items[ing.Name] = &other
+
+	ip.deleteIngress(ing)
+	ip.syncStatus()
+	if status := client.status(ing.Name); !reflect.DeepEqual(status, other.Status.LoadBalancer.Ingress) {
+		t.Errorf("status published by others should be kept, got %v", status)
+	}
+	if len(ip.publishedStatus) != 0 {
+		t.Errorf("status published by others should be forgotten, got %v", ip.publishedStatus)
+	}
+}
+
+func TestStatusOfDeletedIngress(t *testing.T) {
+	ip, client, ing := setupStatusIngresses(t)
+	client.items[ing.Name] = ing
+
+	ip.syncStatus()
+	delete(client.items, ing.Name)
+	ip.deleteIngress(ing)
+	ip.syncStatus()
+	if len(ip.publishedStatus) != 0 {
+		t.Errorf("status of deleted ingress should be forgotten, got %v", ip.publishedStatus)
+	}
+}
+
+func TestClearStatus(t *testing.T) {
+	ip, client, ing := setupStatusIngresses(t)
+	client.items[ing.Name] = ing
+
+	ip.syncStatus()
+	ip.clearStatus()
+	if status := client.status(ing.Name); len(status) != 0 {
+		t.Errorf("expected the status to be cleared on shutdown, got %v", status)
+	}
+
+	updates := client.updates
+	ip.syncStatus()
+	if client.updates != updates {
+		t.Errorf("status should not be synced after shutdown")
+	}
+}
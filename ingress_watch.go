@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	kube "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+const (
+	watchBackoffMin = 1 * time.Second
+	watchBackoffMax = 60 * time.Second
+	watchTimeout    = 5 * time.Minute
+)
+
+// errWatchExpired is returned when the API server no longer has the
+// requested resource version and a fresh list is needed
+var errWatchExpired = errors.New("watched resource version expired")
+
+// errWatchClosed is returned when a watch ends right away without delivering
+// anything, reconnecting at once would spin against the API server
+var errWatchClosed = errors.New("watch closed without any events")
+
+func nextWatchBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > watchBackoffMax {
+		backoff = watchBackoffMax
+	}
+	return backoff
+}
+
+// setupIngressCache follows the ingresses of the watched namespaces into the
+// ingress store. With a namespace selector it follows the namespaces
+// matching the selector.
+func (ip *IngressProxy) setupIngressCache(client kube.IngressNamespacer) {
+	namespaces := ip.IngressNamespaces
+	if ip.namespaceCache != nil {
+		namespaces = ip.selectedNamespaces()
+	}
+	ip.ingressCache = newResourceCache("ingresses", namespaces,
+		clientSource(
+			func(namespace string, opts api.ListOptions) (runtime.Object, error) {
+				return client.Ingress(namespace).List(opts)
+			},
+			func(namespace string, opts api.ListOptions) (watch.Interface, error) {
+				return client.Ingress(namespace).Watch(opts)
+			},
+			ip.ingressListOptions,
+		),
+		resourceHandler{
+			replaced: ip.ingressesListed,
+			changed:  ip.ingressChanged,
+		},
+	)
+}
+
+// WatchConfig follows all configured namespaces through the watch API, or the
+// ingress files in standalone mode. The last known config keeps serving
+// while the API server can't be reached.
+func (ip *IngressProxy) WatchConfig() {
+	if ip.ingressFileSource != nil {
+		ip.ingressFileSource.run()
+		return
+	}
+	ip.ingressCache.run()
+}
+
+// ingressListOptions selects the ingresses by name and labels and leaves out
+// excluded namespaces
+func (ip *IngressProxy) ingressListOptions(namespace string, opts *api.ListOptions) error {
+	terms := []string{}
+	if len(ip.IngressName) > 0 {
+		terms = append(terms, "metadata.name="+ip.IngressName)
+	}
+	fieldSelector, err := ip.namespaceFieldSelector(namespace, terms...)
+	if err != nil {
+		return err
+	}
+	opts.FieldSelector = fieldSelector
+	if selector := ip.currentConfig().selector; selector != nil {
+		opts.LabelSelector = selector
+	}
+	return nil
+}
+
+// ingressesListed replaces the stored ingresses of a namespace with the
+// listed ones, a namespace no longer watched is replaced without ingresses
+func (ip *IngressProxy) ingressesListed(namespace string, objs []runtime.Object) {
+	if !ip.ingressCache.watches(namespace) {
+		log.Infof("Forgetting ingresses of %s: namespace no longer watched", namespaceString(namespace))
+	} else if len(objs) == 0 {
+		log.Warnf("No ingress found in %s", namespaceString(namespace))
+	}
+
+	ingresses := make([]*extensions.Ingress, 0, len(objs))
+	for _, obj := range objs {
+		ing := obj.(*extensions.Ingress)
+		if claimed, reason := ip.claimsIngress(ing); !claimed {
+			log.Infof("Ignoring ingress %s: %s", ingressKey(ing), reason)
+			continue
+		}
+		ingresses = append(ingresses, ing)
+	}
+	ip.replaceIngresses(namespace, ingresses)
+}
+
+// ingressChanged applies an ingress change seen by the watch
+func (ip *IngressProxy) ingressChanged(eventType watch.EventType, obj runtime.Object) {
+	ing := obj.(*extensions.Ingress)
+	switch eventType {
+	case watch.Added, watch.Modified:
+		ip.storeIngress(ing)
+	case watch.Deleted:
+		ip.deleteIngress(ing)
+	}
+}
+
+func ingressKey(ing *extensions.Ingress) string {
+	return ing.Namespace + "/" + ing.Name
+}
+
+// storedIngress returns the currently applied version of an ingress
+func (ip *IngressProxy) storedIngress(key string) *extensions.Ingress {
+	ip.ingressStoreLock.Lock()
+	defer ip.ingressStoreLock.Unlock()
+	return ip.ingressStore[key]
+}
+
+// validIngress validates an ingress unless it is already applied, invalid
+// ingresses are rejected. Ingresses of namespaces not served are ignored,
+// they are listed again once their namespace is watched.
+func (ip *IngressProxy) validIngress(ing *extensions.Ingress) bool {
+	if reflect.DeepEqual(ip.storedIngress(ingressKey(ing)), ing) {
+		return true
+	}
+	if !ip.namespaceAllowed(ing.Namespace) {
+		return false
+	}
+	if problems := ip.validateIngress(ing); len(problems) > 0 {
+		ip.rejectIngress(ing, problems)
+		return false
+	}
+	ip.acceptIngress(ing)
+	return true
+}
+
+// replaceIngresses swaps all stored ingresses of a namespace, invalid
+// ingresses keep their last-known-good version. Rejected ingresses of the
+// namespace which are gone are forgotten.
+func (ip *IngressProxy) replaceIngresses(namespace string, ingresses []*extensions.Ingress) {
+	valid := make(map[string]bool)
+	for _, ing := range ingresses {
+		valid[ingressKey(ing)] = ip.validIngress(ing)
+	}
+	for _, ing := range ip.rejectedIngresses() {
+		if _, ok := valid[ingressKey(ing)]; !ok && (namespace == api.NamespaceAll || ing.Namespace == namespace) {
+			ip.acceptIngress(ing)
+		}
+	}
+
+	ip.ingressStoreLock.Lock()
+	defer ip.ingressStoreLock.Unlock()
+
+	previous := make(map[string]*extensions.Ingress)
+	for key, ing := range ip.ingressStore {
+		if namespace == api.NamespaceAll || ing.Namespace == namespace {
+			previous[key] = ing
+			delete(ip.ingressStore, key)
+		}
+	}
+	applied := []*extensions.Ingress{}
+	for _, ing := range ingresses {
+		key := ingressKey(ing)
+		if !valid[key] {
+			ing = previous[key]
+		}
+		if ing != nil {
+			ip.ingressStore[key] = ing
+			applied = append(applied, ing)
+		}
+	}
+
+	ip.applyIngressStore()
+	for _, ing := range applied {
+		ip.queueIngressCheck(ing)
+	}
+}
+
+func (ip *IngressProxy) storeIngress(ing *extensions.Ingress) {
+	key := ingressKey(ing)
+	if claimed, reason := ip.claimsIngress(ing); !claimed {
+		log.Infof("Ignoring ingress %s: %s", key, reason)
+		ip.ingressStoreLock.Lock()
+		defer ip.ingressStoreLock.Unlock()
+		if _, ok := ip.ingressStore[key]; ok {
+			// the ingress is no longer meant for us
+			delete(ip.ingressStore, key)
+			ip.applyIngressStore()
+		}
+		return
+	}
+
+	// validation talks to the API server, so it runs without holding the lock
+	if !ip.validIngress(ing) {
+		return
+	}
+
+	ip.ingressStoreLock.Lock()
+	defer ip.ingressStoreLock.Unlock()
+
+	if reflect.DeepEqual(ip.ingressStore[key], ing) {
+		return
+	}
+
+	log.Infof("Upgrade ingress config %s to resourceVersion=%s", key, ing.ResourceVersion)
+	ip.ingressStore[key] = ing
+	ip.applyIngressStore()
+	ip.queueIngressCheck(ing)
+}
+
+func (ip *IngressProxy) deleteIngress(ing *extensions.Ingress) {
+	ip.ingressStoreLock.Lock()
+	defer ip.ingressStoreLock.Unlock()
+
+	key := ingressKey(ing)
+	log.Infof("Ingress %s has been deleted", key)
+	ip.acceptIngress(ing)
+	delete(ip.ingressStore, key)
+	ip.applyIngressStore()
+}
+
+// applyIngressStore hands the stored ingresses of allowed namespaces over to
+// routing, the store lock has to be held by the caller
+func (ip *IngressProxy) applyIngressStore() {
+	ingresses := make([]*extensions.Ingress, 0, len(ip.ingressStore))
+	for _, ing := range ip.ingressStore {
+		if ip.namespaceAllowed(ing.Namespace) {
+			ingresses = append(ingresses, ing)
+		}
+	}
+	ip.SetIngresses(ingresses)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	kube "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+// fakeIngresses is an ingress client of all namespaces, every list and watch
+// call is handed to the test which answers it
+type fakeIngresses struct {
+	kube.IngressInterface
+	lists        chan api.ListOptions
+	listResults  chan fakeListResult
+	watches      chan api.ListOptions
+	watchResults chan fakeWatchResult
+}
+
+type fakeListResult struct {
+	list *extensions.IngressList
+	err  error
+}
+
+type fakeWatchResult struct {
+	watcher watch.Interface
+	err     error
+}
+
+func newFakeIngresses() *fakeIngresses {
+	return &fakeIngresses{
+		lists:        make(chan api.ListOptions),
+		listResults:  make(chan fakeListResult),
+		watches:      make(chan api.ListOptions),
+		watchResults: make(chan fakeWatchResult),
+	}
+}
+
+func (f *fakeIngresses) Ingress(namespace string) kube.IngressInterface {
+	return f
+}
+
+func (f *fakeIngresses) List(opts api.ListOptions) (*extensions.IngressList, error) {
+	f.lists <- opts
+	result := <-f.listResults
+	return result.list, result.err
+}
+
+func (f *fakeIngresses) Watch(opts api.ListOptions) (watch.Interface, error) {
+	f.watches <- opts
+	result := <-f.watchResults
+	return result.watcher, result.err
+}
+
+// expectList answers the next list call with the ingresses
+func (f *fakeIngresses) expectList(t *testing.T, resourceVersion string, ingresses ...*extensions.Ingress) {
+	select {
+	case <-f.lists:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the ingresses to be listed")
+	}
+	list := &extensions.IngressList{ListMeta: unversioned.ListMeta{ResourceVersion: resourceVersion}}
+	for _, ing := range ingresses {
+		list.Items = append(list.Items, *ing)
+	}
+	f.listResults <- fakeListResult{list: list}
+}
+
+// expectWatch answers the next watch call, which has to start from the
+// resource version, with a fake watcher or an error
+func (f *fakeIngresses) expectWatch(t *testing.T, resourceVersion string, err error) *watch.FakeWatcher {
+	select {
+	case opts := <-f.watches:
+		if opts.ResourceVersion != resourceVersion {
+			t.Errorf("expected watch from resourceVersion=%s, got %s", resourceVersion, opts.ResourceVersion)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the ingresses to be watched")
+	}
+	if err != nil {
+		f.watchResults <- fakeWatchResult{err: err}
+		return nil
+	}
+	fake := watch.NewFake()
+	f.watchResults <- fakeWatchResult{watcher: fake}
+	return fake
+}
+
+// expectNoCall fails if the ingresses are listed or watched again before
+// the backoff
+func (f *fakeIngresses) expectNoCall(t *testing.T, msg string) {
+	select {
+	case <-f.lists:
+		t.Fatalf("%s, got a list", msg)
+	case <-f.watches:
+		t.Fatalf("%s, got a watch", msg)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func watchedIngress(t *testing.T, ip *IngressProxy, resourceVersion, serviceName string) *extensions.Ingress {
+	copied, err := api.Scheme.DeepCopy(ip.ingresses()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ing := copied.(*extensions.Ingress)
+	ing.ResourceVersion = resourceVersion
+	ing.Spec.Backend.ServiceName = serviceName
+	return ing
+}
+
+func setupFakeIngressCache(t *testing.T) (*IngressProxy, *fakeIngresses) {
+	ip := exampleIngress()
+	ip.IngressNamespaces = []string{api.NamespaceAll}
+	client := newFakeIngresses()
+	ip.setupIngressCache(client)
+
+	synced := make(chan error)
+	go func() {
+		synced <- ip.ingressCache.sync()
+	}()
+	client.expectList(t, "10", watchedIngress(t, ip, "5", "service1"))
+	if err := <-synced; err != nil {
+		t.Fatal(err)
+	}
+	return ip, client
+}
+
+func servedVersion(ip *IngressProxy, key string) string {
+	if ing := ip.storedIngress(key); ing != nil {
+		return ing.ResourceVersion + "/" + ing.Spec.Backend.ServiceName
+	}
+	return ""
+}
+
+func TestIngressWatch(t *testing.T) {
+	ip, client := setupFakeIngressCache(t)
+	defer ip.ingressCache.setNamespaces(nil)
+	key := "default/ingress1"
+	if served := servedVersion(ip, key); served != "5/service1" {
+		t.Fatalf("expected the listed ingress to be served, got %s", served)
+	}
+
+	ip.ingressCache.run()
+
+	// the watch continues from the listed resource version and the next
+	// watch from the last seen one
+	fake := client.expectWatch(t, "10", nil)
+	fake.Modify(watchedIngress(t, ip, "11", "service5"))
+	fake.Stop()
+	eventually(t, func() bool { return servedVersion(ip, key) == "11/service5" }, "modified ingress not served")
+
+	// an expired resource version is listed again right away
+	fake = client.expectWatch(t, "11", nil)
+	fake.Error(&unversioned.Status{Code: http.StatusGone})
+	client.expectList(t, "20", watchedIngress(t, ip, "12", "service6"))
+	eventually(t, func() bool { return servedVersion(ip, key) == "12/service6" }, "re-listed ingress not served")
+
+	// failing watches are retried with a backoff, the last known ingresses
+	// keep being served
+	client.expectWatch(t, "20", errors.New("connection refused"))
+	client.expectNoCall(t, "expected a backoff after the failed watch")
+	if served := servedVersion(ip, key); served != "12/service6" {
+		t.Errorf("expected the last known ingress to be served, got %s", served)
+	}
+}
+
+func TestIngressWatchClosedRightAway(t *testing.T) {
+	ip, client := setupFakeIngressCache(t)
+	defer ip.ingressCache.setNamespaces(nil)
+
+	ip.ingressCache.run()
+	client.expectWatch(t, "10", nil).Stop()
+	client.expectNoCall(t, "expected a backoff after a watch closed without events")
+	if served := servedVersion(ip, "default/ingress1"); served != "5/service1" {
+		t.Errorf("expected the listed ingress to be served, got %s", served)
+	}
+}
+
+func TestIngressListFailure(t *testing.T) {
+	ip, client := setupFakeIngressCache(t)
+	defer ip.ingressCache.setNamespaces(nil)
+
+	ip.ingressCache.run()
+	client.expectWatch(t, "10", nil).Error(&unversioned.Status{Code: http.StatusGone})
+	<-client.lists
+	client.listResults <- fakeListResult{err: errors.New("connection refused")}
+	client.expectNoCall(t, "expected a backoff after the failed list")
+	if served := servedVersion(ip, "default/ingress1"); served != "5/service1" {
+		t.Errorf("expected the last known ingress to be served, got %s", served)
+	}
+}
+
+func TestNextWatchBackoff(t *testing.T) {
+	backoff := watchBackoffMin
+	for _, expected := range []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second} {
+		backoff = nextWatchBackoff(backoff)
+		if backoff != expected {
+			t.Errorf("expected a backoff of %s, got %s", expected, backoff)
+		}
+	}
+	if backoff = nextWatchBackoff(watchBackoffMax); backoff != watchBackoffMax {
+		t.Errorf("expected the backoff to be capped at %s, got %s", watchBackoffMax, backoff)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/pflag"
+	kube "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/client/unversioned/clientcmd"
+	clientcmdapi "k8s.io/kubernetes/pkg/client/unversioned/clientcmd/api"
+)
+
+// KubeClientConfig selects how the proxy connects to the API server
+type KubeClientConfig struct {
+	Kubeconfig string `yaml:"kubeconfig"`
+	Context    string `yaml:"context"`
+	Master     string `yaml:"master"`
+}
+
+// AddFlags registers the API server connection flags
+func (c *KubeClientConfig) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&c.Kubeconfig, "kubeconfig", c.Kubeconfig, "Path to a kubeconfig file, the in-cluster config is used if no kubeconfig is given")
+	fs.StringVar(&c.Context, "context", c.Context, "Name of the kubeconfig context to use")
+	fs.StringVar(&c.Master, "master", c.Master, "Address of the Kubernetes API server, overrides the kubeconfig")
+}
+
+// outOfCluster is true if a kubeconfig should be used instead of the
+// service account of the pod
+func (c *KubeClientConfig) outOfCluster() bool {
+	return len(c.Kubeconfig) > 0 ||
+		len(c.Context) > 0 ||
+		len(c.Master) > 0 ||
+		len(os.Getenv(clientcmd.RecommendedConfigPathEnvVar)) > 0
+}
+
+func (ip *IngressProxy) getKubeClient() (*kube.Client, error) {
+	if !ip.KubeClientConfig.outOfCluster() {
+		log.Infof("Using in-cluster config to connect to the API server")
+		return kube.NewInCluster()
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = ip.KubeClientConfig.Kubeconfig
+
+	overrides := &clientcmd.ConfigOverrides{
+		CurrentContext: ip.KubeClientConfig.Context,
+		ClusterInfo: clientcmdapi.Cluster{
+			Server: ip.KubeClientConfig.Master,
+		},
+	}
+
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Using kubeconfig to connect to the API server at %s", config.Host)
+	return kube.New(config)
+}
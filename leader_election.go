@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"k8s.io/kubernetes/pkg/api"
+	apierrors "k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	kube "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/util/wait"
+)
+
+// leaderAnnotation holds the leader election record on the lock object
+const leaderAnnotation = "control-plane.alpha.kubernetes.io/leader"
+
+// errLockNotFound is returned by a resourceLock if the object doesn't exist
+var errLockNotFound = errors.New("leader election object not found")
+
+// leaderElectionRecord is stored as JSON in the leader annotation
+type leaderElectionRecord struct {
+	HolderIdentity       string           `json:"holderIdentity"`
+	LeaseDurationSeconds int              `json:"leaseDurationSeconds"`
+	AcquireTime          unversioned.Time `json:"acquireTime"`
+	RenewTime            unversioned.Time `json:"renewTime"`
+}
+
+// resourceLock stores the leader election record on a Kubernetes object
+type resourceLock interface {
+	Get() (*leaderElectionRecord, error)
+	Create(record leaderElectionRecord) error
+	Update(record leaderElectionRecord) error
+	String() string
+}
+
+// newResourceLock returns a lock on an endpoints or configmaps object
+func newResourceLock(kubeClient *kube.Client, kind, namespace, name string) (resourceLock, error) {
+	switch kind {
+	case "endpoints":
+		return &endpointsLock{client: kubeClient.Endpoints(namespace), namespace: namespace, name: name}, nil
+	case "configmaps":
+		return &configMapLock{client: kubeClient.ConfigMaps(namespace), namespace: namespace, name: name}, nil
+	}
+	return nil, fmt.Errorf("Unknown leader election resource '%s', expected 'endpoints' or 'configmaps'", kind)
+}
+
+func recordFromAnnotations(annotations map[string]string) (*leaderElectionRecord, error) {
+	record := &leaderElectionRecord{}
+	if value, ok := annotations[leaderAnnotation]; ok {
+		if err := json.Unmarshal([]byte(value), record); err != nil {
+			return nil, err
+		}
+	}
+	return record, nil
+}
+
+func setRecordAnnotation(meta *api.ObjectMeta, record leaderElectionRecord) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if meta.Annotations == nil {
+		meta.Annotations = make(map[string]string)
+	}
+	meta.Annotations[leaderAnnotation] = string(value)
+	return nil
+}
+
+type endpointsLock struct {
+	client    kube.EndpointsInterface
+	namespace string
+	name      string
+	endpoints *api.Endpoints
+}
+
+func (l *endpointsLock) Get() (*leaderElectionRecord, error) {
+	endpoints, err := l.client.Get(l.name)
+	if apierrors.IsNotFound(err) {
+		return nil, errLockNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	l.endpoints = endpoints
+	return recordFromAnnotations(endpoints.Annotations)
+}
+
+func (l *endpointsLock) Create(record leaderElectionRecord) error {
+	endpoints := &api.Endpoints{
+		ObjectMeta: api.ObjectMeta{
+			Name:      l.name,
+			Namespace: l.namespace,
+		},
+	}
+	if err := setRecordAnnotation(&endpoints.ObjectMeta, record); err != nil {
+		return err
+	}
+	endpoints, err := l.client.Create(endpoints)
+	if err == nil {
+		l.endpoints = endpoints
+	}
+	return err
+}
+
+func (l *endpointsLock) Update(record leaderElectionRecord) error {
+	if l.endpoints == nil {
+		return errLockNotFound
+	}
+	if err := setRecordAnnotation(&l.endpoints.ObjectMeta, record); err != nil {
+		return err
+	}
+	endpoints, err := l.client.Update(l.endpoints)
+	if err == nil {
+		l.endpoints = endpoints
+	}
+	return err
+}
+
+func (l *endpointsLock) String() string {
+	return fmt.Sprintf("endpoints %s/%s", l.namespace, l.name)
+}
+
+type configMapLock struct {
+	client    kube.ConfigMapsInterface
+	namespace string
+	name      string
+	configMap *api.ConfigMap
+}
+
+func (l *configMapLock) Get() (*leaderElectionRecord, error) {
+	configMap, err := l.client.Get(l.name)
+	if apierrors.IsNotFound(err) {
+		return nil, errLockNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	l.configMap = configMap
+	return recordFromAnnotations(configMap.Annotations)
+}
+
+func (l *configMapLock) Create(record leaderElectionRecord) error {
+	configMap := &api.ConfigMap{
+		ObjectMeta: api.ObjectMeta{
+			Name:      l.name,
+			Namespace: l.namespace,
+		},
+	}
+	if err := setRecordAnnotation(&configMap.ObjectMeta, record); err != nil {
+		return err
+	}
+	configMap, err := l.client.Create(configMap)
+	if err == nil {
+		l.configMap = configMap
+	}
+	return err
+}
+
+func (l *configMapLock) Update(record leaderElectionRecord) error {
+	if l.configMap == nil {
+		return errLockNotFound
+	}
+	if err := setRecordAnnotation(&l.configMap.ObjectMeta, record); err != nil {
+		return err
+	}
+	configMap, err := l.client.Update(l.configMap)
+	if err == nil {
+		l.configMap = configMap
+	}
+	return err
+}
+
+func (l *configMapLock) String() string {
+	return fmt.Sprintf("configmap %s/%s", l.namespace, l.name)
+}
+
+// leaderElector makes sure only a single replica does cluster-mutating work.
+// The leader renews its lease every retry period, if it fails to do so within
+// the renew deadline it steps down. Other replicas take over once the lease
+// has not been renewed for the lease duration.
+type leaderElector struct {
+	lock          resourceLock
+	identity      string
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+
+	// onStartedLeading runs the leader's work until stop is closed
+	onStartedLeading func(stop <-chan struct{})
+
+	observedRecord leaderElectionRecord
+	observedTime   time.Time
+
+	leaderLock sync.RWMutex
+	leader     string
+}
+
+func newLeaderElector(lock resourceLock, identity string, c *Config) *leaderElector {
+	return &leaderElector{
+		lock:          lock,
+		identity:      identity,
+		leaseDuration: c.LeaderElectLeaseDuration,
+		renewDeadline: c.LeaderElectRenewDeadline,
+		retryPeriod:   c.LeaderElectRetryPeriod,
+	}
+}
+
+// leaderElectionIdentity identifies this replica, the pod name if available
+func leaderElectionIdentity() string {
+	if name := os.Getenv("POD_NAME"); len(name) > 0 {
+		return name
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Sprintf("%s-%d", appName, os.Getpid())
+	}
+	return hostname
+}
+
+// run takes part in the election forever
+func (le *leaderElector) run() {
+	log.Infof("Starting leader election on %s as %s", le.lock, le.identity)
+
+	for {
+		le.acquire()
+
+		stop := make(chan struct{})
+		go le.onStartedLeading(stop)
+
+		le.renew()
+		close(stop)
+	}
+}
+
+// acquire blocks until the lease is held by this replica
+func (le *leaderElector) acquire() {
+	for {
+		if le.tryAcquireOrRenew() {
+			log.Infof("Acquired leadership on %s", le.lock)
+			return
+		}
+		time.Sleep(wait.Jitter(le.retryPeriod, 1.2))
+	}
+}
+
+// renew keeps the lease until it couldn't be renewed within the deadline
+func (le *leaderElector) renew() {
+	for {
+		err := wait.Poll(le.retryPeriod, le.renewDeadline, func() (bool, error) {
+			return le.tryAcquireOrRenew(), nil
+		})
+		if err != nil {
+			log.Warnf("Lost leadership on %s: %s", le.lock, err)
+			le.setLeader("")
+			return
+		}
+	}
+}
+
+func (le *leaderElector) tryAcquireOrRenew() bool {
+	now := unversioned.Now()
+	record := leaderElectionRecord{
+		HolderIdentity:       le.identity,
+		LeaseDurationSeconds: int(le.leaseDuration / time.Second),
+		AcquireTime:          now,
+		RenewTime:            now,
+	}
+
+	old, err := le.lock.Get()
+	if err == errLockNotFound {
+		if err := le.lock.Create(record); err != nil {
+			log.Warnf("Error creating %s: %s", le.lock, err)
+			return false
+		}
+		le.observedRecord = record
+		le.observedTime = time.Now()
+		le.setLeader(le.identity)
+		return true
+	} else if err != nil {
+		log.Warnf("Error getting %s: %s", le.lock, err)
+		return false
+	}
+
+	if !reflect.DeepEqual(le.observedRecord, *old) {
+		le.observedRecord = *old
+		le.observedTime = time.Now()
+	}
+
+	if len(old.HolderIdentity) > 0 && old.HolderIdentity != le.identity &&
+		le.observedTime.Add(le.leaseDuration).After(time.Now()) {
+		// someone else holds a valid lease
+		le.setLeader(old.HolderIdentity)
+		return false
+	}
+
+	if old.HolderIdentity == le.identity {
+		record.AcquireTime = old.AcquireTime
+	}
+
+	if err := le.lock.Update(record); err != nil {
+		log.Warnf("Error updating %s: %s", le.lock, err)
+		return false
+	}
+	le.observedRecord = record
+	le.observedTime = time.Now()
+	le.setLeader(le.identity)
+	return true
+}
+
+func (le *leaderElector) setLeader(leader string) {
+	le.leaderLock.Lock()
+	defer le.leaderLock.Unlock()
+
+	if leader == le.leader {
+		return
+	}
+
+	if leader == le.identity || le.leader == le.identity {
+		leaderTransitionsMetric.Inc()
+	}
+	if len(leader) > 0 {
+		log.Infof("New leader elected: %s", leader)
+	}
+
+	le.leader = leader
+	leaderMetric.Reset()
+	if len(leader) > 0 {
+		leaderMetric.WithLabelValues(leader).Set(1)
+	}
+	if leader == le.identity {
+		isLeaderMetric.Set(1)
+	} else {
+		isLeaderMetric.Set(0)
+	}
+}
+
+func (le *leaderElector) getLeader() string {
+	le.leaderLock.RLock()
+	defer le.leaderLock.RUnlock()
+	return le.leader
+}
+
+func (le *leaderElector) isLeader() bool {
+	return le.getLeader() == le.identity
+}
+
+// isLeader is true if this replica may do cluster-mutating work, which is
+// always the case without leader election
+func (ip *IngressProxy) isLeader() bool {
+	if ip.leaderElector == nil {
+		return true
+	}
+	return ip.leaderElector.isLeader()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// memoryLock is a resourceLock kept in memory
+type memoryLock struct {
+	record *leaderElectionRecord
+}
+
+func (l *memoryLock) Get() (*leaderElectionRecord, error) {
+	if l.record == nil {
+		return nil, errLockNotFound
+	}
+	record := *l.record
+	return &record, nil
+}
+
+func (l *memoryLock) Create(record leaderElectionRecord) error {
+	l.record = &record
+	return nil
+}
+
+func (l *memoryLock) Update(record leaderElectionRecord) error {
+	l.record = &record
+	return nil
+}
+
+func (l *memoryLock) String() string {
+	return "memory"
+}
+
+func TestLeaderElection(t *testing.T) {
+	lock := &memoryLock{}
+	config := NewConfig()
+	config.LeaderElectLeaseDuration = 50 * time.Millisecond
+
+	a := newLeaderElector(lock, "a", config)
+	b := newLeaderElector(lock, "b", config)
+
+	if !a.tryAcquireOrRenew() || !a.isLeader() {
+		t.Fatalf("a should acquire the free lock")
+	}
+	if b.tryAcquireOrRenew() || b.isLeader() {
+		t.Fatalf("b should not acquire the lock held by a")
+	}
+	if b.getLeader() != "a" {
+		t.Errorf("b should observe a as leader, got '%s'", b.getLeader())
+	}
+	if !a.tryAcquireOrRenew() {
+		t.Errorf("a should renew its own lease")
+	}
+
+	// a stops renewing, b takes over once the lease expired
+	time.Sleep(2 * config.LeaderElectLeaseDuration)
+	if b.tryAcquireOrRenew() {
+		t.Errorf("b should not take over before observing the lease for its duration")
+	}
+	time.Sleep(2 * config.LeaderElectLeaseDuration)
+	if !b.tryAcquireOrRenew() || !b.isLeader() {
+		t.Errorf("b should acquire the expired lock")
+	}
+}
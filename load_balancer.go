@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// Load balancing algorithms pick the endpoint a request is sent to when
+// balancing across the endpoints of a backend. Round robin takes the
+// endpoints in turn, weighted round robin does the same but picks endpoints
+// by their weight. Least outstanding picks the endpoint with the fewest
+// requests in flight. The power of two choices compares two random endpoints
+// by their moving average latency, weighed with the requests in flight.
+// Random picks any endpoint. Consistent hashing sends requests with the same
+// key to the same endpoint as long as it is available, the key is a header
+// as in 'consistent-hash:header:X-User', a cookie as in
+// 'consistent-hash:cookie:session' or the client IP with 'consistent-hash:ip'.
+// Requests without key are balanced randomly.
+const (
+	loadBalanceRoundRobin         = "round-robin"
+	loadBalanceWeightedRoundRobin = "weighted-round-robin"
+	loadBalanceLeastOutstanding   = "least-outstanding"
+	loadBalanceP2CEWMA            = "p2c-ewma"
+	loadBalanceRandom             = "random"
+	loadBalanceConsistentHash     = "consistent-hash"
+)
+
+// balancer picks an endpoint for a request out of a non-empty list of
+// candidates. Requests are retried with the endpoints tried before left out
+// of the candidates.
+type balancer interface {
+	pick(r *http.Request, candidates []*endpoint) *endpoint
+}
+
+// newBalancer creates the balancer of an algorithm and its parameter,
+// separated by ':'
+func newBalancer(spec string) (balancer, error) {
+	algorithm, parameter := spec, ""
+	if pos := strings.Index(spec, ":"); pos >= 0 {
+		algorithm, parameter = spec[:pos], spec[pos+1:]
+	}
+	if algorithm == loadBalanceConsistentHash {
+		return newHashBalancer(parameter)
+	}
+
+	var b balancer
+	switch algorithm {
+	case loadBalanceRoundRobin:
+		b = &roundRobinBalancer{}
+	case loadBalanceWeightedRoundRobin:
+		b = &weightedRoundRobinBalancer{}
+	case loadBalanceLeastOutstanding:
+		b = &leastOutstandingBalancer{}
+	case loadBalanceP2CEWMA:
+		b = p2cEWMABalancer{}
+	case loadBalanceRandom:
+		b = randomBalancer{}
+	default:
+		return nil, fmt.Errorf("unknown load balancing algorithm '%s'", algorithm)
+	}
+	if len(parameter) > 0 {
+		return nil, fmt.Errorf("load balancing algorithm '%s' takes no parameter", algorithm)
+	}
+	return b, nil
+}
+
+func validateLoadBalance(spec string) error {
+	_, err := newBalancer(spec)
+	return err
+}
+
+type roundRobinBalancer struct {
+	next uint32
+}
+
+func (b *roundRobinBalancer) pick(r *http.Request, candidates []*endpoint) *endpoint {
+	n := atomic.AddUint32(&b.next, 1) - 1
+	return candidates[int(n%uint32(len(candidates)))]
+}
+
+// weightedRoundRobinBalancer spreads the picks of each endpoint evenly,
+// every endpoint gains its weight per pick and the one picked loses the
+// total weight
+type weightedRoundRobinBalancer struct {
+	lock sync.Mutex
+}
+
+func (b *weightedRoundRobinBalancer) pick(r *http.Request, candidates []*endpoint) *endpoint {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	var best *endpoint
+	total := 0
+	for _, e := range candidates {
+		e.currentWeight += e.weight
+		total += e.weight
+		if best == nil || e.currentWeight > best.currentWeight {
+			best = e
+		}
+	}
+	best.currentWeight -= total
+	return best
+}
+
+type leastOutstandingBalancer struct {
+	next uint32
+}
+
+func (b *leastOutstandingBalancer) pick(r *http.Request, candidates []*endpoint) *endpoint {
+	// ties are broken round robin
+	start := int((atomic.AddUint32(&b.next, 1) - 1) % uint32(len(candidates)))
+	best := candidates[start]
+	for i := 1; i < len(candidates); i++ {
+		e := candidates[(start+i)%len(candidates)]
+		if e.inFlight() < best.inFlight() {
+			best = e
+		}
+	}
+	return best
+}
+
+type p2cEWMABalancer struct{}
+
+func (p2cEWMABalancer) pick(r *http.Request, candidates []*endpoint) *endpoint {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+	i := rand.Intn(len(candidates))
+	j := rand.Intn(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+	if candidates[j].cost() < candidates[i].cost() {
+		return candidates[j]
+	}
+	return candidates[i]
+}
+
+type randomBalancer struct{}
+
+func (randomBalancer) pick(r *http.Request, candidates []*endpoint) *endpoint {
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// hashBalancer uses rendezvous hashing, the endpoint with the highest hash
+// of key and address wins. Endpoints coming and going only move the keys
+// of those endpoints.
+type hashBalancer struct {
+	key func(r *http.Request) string
+}
+
+func newHashBalancer(parameter string) (balancer, error) {
+	source, name := parameter, ""
+	if pos := strings.Index(parameter, ":"); pos >= 0 {
+		source, name = parameter[:pos], parameter[pos+1:]
+	}
+
+	switch {
+	case source == "header" && len(name) > 0:
+		name = http.CanonicalHeaderKey(name)
+		return &hashBalancer{key: func(r *http.Request) string {
+			return r.Header.Get(name)
+		}}, nil
+	case source == "cookie" && len(name) > 0:
+		return &hashBalancer{key: func(r *http.Request) string {
+			if cookie, err := r.Cookie(name); err == nil {
+				return cookie.Value
+			}
+			return ""
+		}}, nil
+	case source == "ip" && len(name) == 0:
+		return &hashBalancer{key: clientIP}, nil
+	}
+	return nil, fmt.Errorf("consistent hashing needs a key of 'header:<name>', 'cookie:<name>' or 'ip', got '%s'", parameter)
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (b *hashBalancer) pick(r *http.Request, candidates []*endpoint) *endpoint {
+	key := b.key(r)
+	if len(key) == 0 {
+		return randomBalancer{}.pick(r, candidates)
+	}
+
+	keyHash := fnvString(fnvOffset, key)
+	var best *endpoint
+	var bestScore uint64
+	for _, e := range candidates {
+		if score := mix(fnvString(keyHash, e.address)); best == nil || score > bestScore {
+			best, bestScore = e, score
+		}
+	}
+	return best
+}
+
+const (
+	fnvOffset = 14695981039346656037
+	fnvPrime  = 1099511628211
+)
+
+// fnvString continues a 64 bit FNV-1a hash with a string
+func fnvString(h uint64, s string) uint64 {
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= fnvPrime
+	}
+	return h
+}
+
+// mix spreads the bits of a hash, FNV of similar addresses differs too
+// little in the high bits to compare hashes directly
+func mix(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
+
+// loadBalanceFor returns the load balancing algorithm of a backend
+func (s *ingressSettings) loadBalanceFor(b *extensions.IngressBackend) string {
+	if algorithm, ok := s.LoadBalanceBackends[b.ServiceName+":"+b.ServicePort.String()]; ok {
+		return algorithm
+	}
+	return s.LoadBalance
+}
+
+// loadBalanceWeightsKey lists the endpoint weights in a stable order
+func (s *ingressSettings) loadBalanceWeightsKey() string {
+	weights := make([]string, 0, len(s.LoadBalanceWeights))
+	for name, weight := range s.LoadBalanceWeights {
+		weights = append(weights, fmt.Sprintf("%s=%d", name, weight))
+	}
+	sort.Strings(weights)
+	return strings.Join(weights, ",")
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+func exampleEndpointList(weights ...int) []*endpoint {
+	endpoints := []*endpoint{}
+	for i, weight := range weights {
+		endpoints = append(endpoints, &endpoint{address: fmt.Sprintf("10.0.0.%d:8080", i+1), weight: weight})
+	}
+	return endpoints
+}
+
+// distribution picks endpoints for a number of requests and counts the
+// picks per endpoint
+func distribution(t *testing.T, spec string, endpoints []*endpoint, requests int, request func(i int) *http.Request) map[string]int {
+	b, err := newBalancer(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	picks := make(map[string]int)
+	for i := 0; i < requests; i++ {
+		picks[b.pick(request(i), endpoints).address]++
+	}
+	return picks
+}
+
+func plainRequest(i int) *http.Request {
+	return httptest.NewRequest("GET", "http://www.test.de/", nil)
+}
+
+func headerRequest(i int) *http.Request {
+	r := plainRequest(i)
+	r.Header.Set("X-User", fmt.Sprintf("user-%d", i))
+	return r
+}
+
+func within(count, expected, tolerance int) bool {
+	return count >= expected-tolerance && count <= expected+tolerance
+}
+
+func TestLoadBalanceSpecs(t *testing.T) {
+	for _, spec := range []string{
+		"round-robin",
+		"weighted-round-robin",
+		"least-outstanding",
+		"p2c-ewma",
+		"random",
+		"consistent-hash:header:X-User",
+		"consistent-hash:cookie:session",
+		"consistent-hash:ip",
+	} {
+		if err := validateLoadBalance(spec); err != nil {
+			t.Errorf("valid spec '%s' rejected: %s", spec, err)
+		}
+	}
+	for _, spec := range []string{
+		"",
+		"round-robin:1",
+		"least-connections",
+		"consistent-hash",
+		"consistent-hash:header",
+		"consistent-hash:ip:1",
+		"consistent-hash:uri",
+	} {
+		if err := validateLoadBalance(spec); err == nil {
+			t.Errorf("invalid spec '%s' accepted", spec)
+		}
+	}
+}
+
+func TestRoundRobinDistribution(t *testing.T) {
+	picks := distribution(t, loadBalanceRoundRobin, exampleEndpointList(1, 1, 1, 1), 400, plainRequest)
+	for address, count := range picks {
+		if count != 100 {
+			t.Errorf("endpoint %s picked %d times, expected 100", address, count)
+		}
+	}
+}
+
+func TestWeightedRoundRobinDistribution(t *testing.T) {
+	endpoints := exampleEndpointList(1, 2, 3)
+
+	// every window of the total weight picks endpoints by their weight
+	b, _ := newBalancer(loadBalanceWeightedRoundRobin)
+	for window := 0; window < 10; window++ {
+		picks := make(map[*endpoint]int)
+		for i := 0; i < 6; i++ {
+			picks[b.pick(nil, endpoints)]++
+		}
+		for _, e := range endpoints {
+			if picks[e] != e.weight {
+				t.Fatalf("endpoint %s with weight %d picked %d times in window %d", e.address, e.weight, picks[e], window)
+			}
+		}
+	}
+}
+
+func TestLeastOutstandingDistribution(t *testing.T) {
+	endpoints := exampleEndpointList(1, 1, 1)
+	endpoints[0].outstanding = 3
+	endpoints[2].outstanding = 1
+	picks := distribution(t, loadBalanceLeastOutstanding, endpoints, 100, plainRequest)
+	if picks["10.0.0.2:8080"] != 100 {
+		t.Errorf("endpoint with the fewest requests in flight not picked: %v", picks)
+	}
+
+	// equally loaded endpoints are taken in turn
+	picks = distribution(t, loadBalanceLeastOutstanding, exampleEndpointList(1, 1, 1), 300, plainRequest)
+	for address, count := range picks {
+		if count != 100 {
+			t.Errorf("endpoint %s picked %d times, expected 100", address, count)
+		}
+	}
+}
+
+func TestP2CEWMADistribution(t *testing.T) {
+	endpoints := exampleEndpointList(1, 1, 1)
+	endpoints[0].observe(10 * time.Millisecond)
+	endpoints[1].observe(10 * time.Millisecond)
+	endpoints[2].observe(100 * time.Millisecond)
+
+	picks := distribution(t, loadBalanceP2CEWMA, endpoints, 3000, plainRequest)
+	if picks["10.0.0.3:8080"] != 0 {
+		t.Errorf("slow endpoint picked %d times", picks["10.0.0.3:8080"])
+	}
+	if !within(picks["10.0.0.1:8080"], 1500, 300) || !within(picks["10.0.0.2:8080"], 1500, 300) {
+		t.Errorf("fast endpoints not balanced: %v", picks)
+	}
+
+	// requests in flight make a fast endpoint expensive
+	endpoints[0].outstanding = 20
+	picks = distribution(t, loadBalanceP2CEWMA, endpoints, 3000, plainRequest)
+	if picks["10.0.0.1:8080"] != 0 {
+		t.Errorf("busy endpoint picked %d times", picks["10.0.0.1:8080"])
+	}
+
+	// the moving average follows the latency
+	e := &endpoint{}
+	for i := 0; i < 20; i++ {
+		e.observe(100 * time.Millisecond)
+	}
+	for i := 0; i < 20; i++ {
+		e.observe(10 * time.Millisecond)
+	}
+	if e.latency > 11*time.Millisecond {
+		t.Errorf("moving average at %s after 20 requests of 10ms", e.latency)
+	}
+}
+
+func TestRandomDistribution(t *testing.T) {
+	picks := distribution(t, loadBalanceRandom, exampleEndpointList(1, 1, 1, 1), 10000, plainRequest)
+	for address, count := range picks {
+		if !within(count, 2500, 250) {
+			t.Errorf("endpoint %s picked %d times of 10000", address, count)
+		}
+	}
+}
+
+func TestConsistentHashDistribution(t *testing.T) {
+	endpoints := exampleEndpointList(1, 1, 1, 1)
+	b, err := newBalancer("consistent-hash:header:x-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	picks := distribution(t, "consistent-hash:header:X-User", endpoints, 10000, headerRequest)
+	for address, count := range picks {
+		if !within(count, 2500, 250) {
+			t.Errorf("endpoint %s picked for %d keys of 10000", address, count)
+		}
+	}
+
+	// keys stay on their endpoint, only keys of a removed endpoint move
+	before := make(map[int]*endpoint)
+	for i := 0; i < 1000; i++ {
+		before[i] = b.pick(headerRequest(i), endpoints)
+		if e := b.pick(headerRequest(i), endpoints); e != before[i] {
+			t.Fatalf("key %d moved from %s to %s", i, before[i].address, e.address)
+		}
+	}
+	remaining := withoutEndpoint(endpoints, endpoints[0])
+	for i := 0; i < 1000; i++ {
+		if e := b.pick(headerRequest(i), remaining); before[i] != endpoints[0] && e != before[i] {
+			t.Errorf("key %d moved from %s to %s after removing %s", i, before[i].address, e.address, endpoints[0].address)
+		}
+	}
+
+	// cookies and client IPs are keys as well
+	cookie, _ := newBalancer("consistent-hash:cookie:session")
+	ip, _ := newBalancer("consistent-hash:ip")
+	cookieRequest := plainRequest(0)
+	cookieRequest.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+	for i := 0; i < 10; i++ {
+		if cookie.pick(cookieRequest, endpoints) != cookie.pick(cookieRequest, endpoints) {
+			t.Error("same cookie picked different endpoints")
+		}
+		if ip.pick(plainRequest(i), endpoints) != ip.pick(plainRequest(i), endpoints) {
+			t.Error("same client IP picked different endpoints")
+		}
+	}
+
+	// requests without key are spread
+	picks = distribution(t, "consistent-hash:header:X-User", endpoints, 1000, plainRequest)
+	if len(picks) != len(endpoints) {
+		t.Errorf("requests without key not spread: %v", picks)
+	}
+}
+
+func TestLoadBalanceAnnotations(t *testing.T) {
+	backend1 := namedBackend("backend1")
+	defer backend1.Close()
+	backend2 := namedBackend("backend2")
+	defer backend2.Close()
+
+	ip := exampleServicePorts(t)
+	ip.endpointsCache = syncedCache(t, "endpoints", exampleEndpoints(t, backend1.Listener.Addr().String(), backend2.Listener.Addr().String()))
+	ing := exampleEndpointsIngress()
+	ing.Annotations[annotationPrefix+"load-balance"] = loadBalanceRandom
+	ing.Annotations[annotationPrefix+"load-balance-backends"] = "web:http=weighted-round-robin"
+	ing.Annotations[annotationPrefix+"load-balance-weights"] = "web-1=3"
+	ip.SetIngresses([]*extensions.Ingress{ing})
+
+	if hits := backendHits(ip, 8); hits["backend1"] != 6 || hits["backend2"] != 2 {
+		t.Errorf("requests not balanced by weight: %v", hits)
+	}
+
+	ing.Annotations[annotationPrefix+"load-balance-weights"] = "web-1=0"
+	if _, problems := parseIngressSettings(ing, ip.currentConfig().Config); len(problems) != 1 || problems[0].Reason != reasonInvalidAnnotation {
+		t.Errorf("expected an invalid weight, got %v", problems)
+	}
+	ing.Annotations[annotationPrefix+"load-balance-weights"] = "web-1=3"
+	ing.Annotations[annotationPrefix+"load-balance-backends"] = "web=round-robin"
+	if _, problems := parseIngressSettings(ing, ip.currentConfig().Config); len(problems) != 1 || problems[0].Reason != reasonInvalidAnnotation {
+		t.Errorf("expected an invalid backend, got %v", problems)
+	}
+}
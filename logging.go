@@ -0,0 +1,50 @@
+package main
+
+import (
+	"sync/atomic"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Loggers read the level and formatter of logrus without locking, so they
+// are set once before anything is logging. The log settings of the config
+// are swapped into the formatter instead, which filters and formats every
+// entry with the settings currently applied.
+
+// logSettings are the log level and formatter of a config
+type logSettings struct {
+	level     log.Level
+	formatter log.Formatter
+}
+
+// reloadableFormatter formats entries with the current log settings and
+// drops entries above their level
+type reloadableFormatter struct {
+	settings atomic.Value
+}
+
+var logFormatter = &reloadableFormatter{}
+
+func init() {
+	logFormatter.settings.Store(&logSettings{level: log.InfoLevel, formatter: &log.TextFormatter{}})
+	log.SetLevel(log.DebugLevel)
+	log.SetFormatter(logFormatter)
+}
+
+func (f *reloadableFormatter) Format(entry *log.Entry) ([]byte, error) {
+	s := f.settings.Load().(*logSettings)
+	if entry.Level > s.level {
+		return nil, nil
+	}
+	return s.formatter.Format(entry)
+}
+
+// setupLogging applies the log settings, it may be called at any time
+func (c *Config) setupLogging() {
+	level, _ := log.ParseLevel(c.LogLevel)
+	s := &logSettings{level: level, formatter: &log.TextFormatter{}}
+	if c.LogFormat == "json" {
+		s.formatter = &log.JSONFormatter{}
+	}
+	logFormatter.settings.Store(s)
+}
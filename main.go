@@ -4,7 +4,7 @@ import (
 	log "github.com/Sirupsen/logrus"
 )
 
-const appName = "kube-inress-proxy"
+const appName = "kube-ingress-proxy"
 const appVersion = "0.0.1"
 
 func main() {
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "kube_ingress_proxy"
+
+var (
+	leaderMetric = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "leader",
+			Help:      "Identity of the current leader among the proxy replicas",
+		},
+		[]string{"identity"},
+	)
+	isLeaderMetric = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "is_leader",
+			Help:      "1 if this replica is the leader, 0 otherwise",
+		},
+	)
+	leaderTransitionsMetric = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "leader_transitions_total",
+			Help:      "Number of times this replica acquired or lost leadership",
+		},
+	)
+	configMapErrorsMetric = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "configmap_errors_total",
+			Help:      "Number of ConfigMap versions which couldn't be applied",
+		},
+	)
+	ingressRejectionsMetric = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "ingress_rejections_total",
+			Help:      "Number of ingress versions rejected by validation",
+		},
+		[]string{"namespace", "ingress"},
+	)
+	ingressRejectedMetric = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "ingress_rejected",
+			Help:      "1 if the latest version of an ingress was rejected and an older one keeps serving",
+		},
+		[]string{"namespace", "ingress"},
+	)
+	endpointHealthyMetric = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "endpoint_healthy",
+			Help:      "1 if the health checks of an endpoint pass, 0 otherwise",
+		},
+		[]string{"namespace", "service", "port", "proxy", "endpoint"},
+	)
+	healthCheckFailuresMetric = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "health_check_failures_total",
+			Help:      "Number of failed health checks of an endpoint",
+		},
+		[]string{"namespace", "service", "port", "proxy", "endpoint"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(leaderMetric)
+	prometheus.MustRegister(isLeaderMetric)
+	prometheus.MustRegister(leaderTransitionsMetric)
+	prometheus.MustRegister(configMapErrorsMetric)
+	prometheus.MustRegister(ingressRejectionsMetric)
+	prometheus.MustRegister(ingressRejectedMetric)
+	prometheus.MustRegister(endpointHealthyMetric)
+	prometheus.MustRegister(healthCheckFailuresMetric)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/meta"
+	"k8s.io/kubernetes/pkg/fields"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+// Namespaces are limited in three ways: an explicit list of namespaces is
+// watched one by one, excluded namespaces are filtered by the API server
+// through a field selector when watching the whole cluster, and the namespace
+// label selector is applied by the proxy based on a cache of the matching
+// namespaces. Ingresses, services, endpoints and secrets are then only
+// watched in the matching namespaces, their caches follow namespaces as they
+// start or stop matching.
+
+// excludedNamespaces returns the namespaces never served
+func (c *Config) excludedNamespaces() map[string]bool {
+	excluded := make(map[string]bool)
+	for _, namespace := range c.ExcludedNamespaces {
+		if namespace = strings.TrimSpace(namespace); len(namespace) > 0 {
+			excluded[namespace] = true
+		}
+	}
+	return excluded
+}
+
+// namespaceFieldSelector returns the field selector to watch objects in a
+// namespace, excluding denied namespaces when watching the whole cluster.
+// Further terms are added to the selector.
+func (ip *IngressProxy) namespaceFieldSelector(namespace string, terms ...string) (fields.Selector, error) {
+	if namespace == api.NamespaceAll {
+		for _, excluded := range ip.currentConfig().ExcludedNamespaces {
+			if excluded = strings.TrimSpace(excluded); len(excluded) > 0 {
+				terms = append(terms, "metadata.namespace!="+excluded)
+			}
+		}
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	return fields.ParseSelector(strings.Join(terms, ","))
+}
+
+// namespaceAllowed decides if ingresses of a namespace are served
+func (ip *IngressProxy) namespaceAllowed(namespace string) bool {
+	if ip.currentConfig().excludedNamespaces()[namespace] {
+		return false
+	}
+	if ip.namespaceCache == nil {
+		return true
+	}
+	_, ok := ip.namespaceCache.get("", namespace)
+	return ok
+}
+
+// setupNamespaceCache follows the namespaces matching the namespace
+// selector, the API server only sends the matching ones
+func (ip *IngressProxy) setupNamespaceCache() error {
+	c := ip.baseConfig
+	if len(c.NamespaceSelector) == 0 {
+		return nil
+	}
+	selector, err := labels.Parse(c.NamespaceSelector)
+	if err != nil {
+		return err
+	}
+
+	client := ip.kubeClient.Namespaces()
+	ip.namespaceCache = newResourceCache("namespaces", []string{api.NamespaceAll},
+		clientSource(
+			func(namespace string, opts api.ListOptions) (runtime.Object, error) {
+				return client.List(opts)
+			},
+			func(namespace string, opts api.ListOptions) (watch.Interface, error) {
+				return client.Watch(opts)
+			},
+			func(namespace string, opts *api.ListOptions) error {
+				opts.LabelSelector = selector
+				return nil
+			},
+		),
+		onAnyChange(func() {
+			ip.followSelectedNamespaces()
+			ip.queueRebuild()
+		}),
+	)
+	return nil
+}
+
+// selectedNamespaces returns the watched namespaces matching the namespace
+// selector, sorted by name
+func (ip *IngressProxy) selectedNamespaces() []string {
+	watched := make(map[string]bool)
+	for _, namespace := range ip.IngressNamespaces {
+		watched[namespace] = true
+	}
+	excluded := ip.currentConfig().excludedNamespaces()
+
+	namespaces := []string{}
+	for _, obj := range ip.namespaceCache.objects() {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			continue
+		}
+		name := accessor.GetName()
+		if excluded[name] || !(watched[api.NamespaceAll] || watched[name]) {
+			continue
+		}
+		namespaces = append(namespaces, name)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// followSelectedNamespaces limits the caches of ingresses, services,
+// endpoints and secrets to the namespaces matching the namespace selector
+func (ip *IngressProxy) followSelectedNamespaces() {
+	caches := []*resourceCache{}
+	if ip.ingressCache != nil {
+		caches = append(caches, ip.ingressCache)
+	}
+	if ip.serviceCache != nil {
+		caches = append(caches, ip.serviceCache, ip.endpointsCache, ip.secretCache)
+	}
+	namespaces := ip.selectedNamespaces()
+	for _, c := range caches {
+		c.setNamespaces(namespaces)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+func TestNamespaceFieldSelector(t *testing.T) {
+	ip := NewIngressProxy()
+	reconfigure(t, ip, func(c *Config) {
+		c.Namespaces = []string{"default", "kube-system", "team-a"}
+		c.ExcludedNamespaces = []string{"kube-system"}
+	})
+
+	namespaces := ip.currentConfig().namespaces()
+	if len(namespaces) != 2 || namespaces[0] != "default" || namespaces[1] != "team-a" {
+		t.Errorf("excluded namespaces should not be watched, got %v", namespaces)
+	}
+
+	selector, err := ip.namespaceFieldSelector(api.NamespaceAll, "metadata.name=ingress1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := selector.String(); s != "metadata.name=ingress1,metadata.namespace!=kube-system" {
+		t.Errorf("unexpected field selector for all namespaces: %s", s)
+	}
+
+	selector, err = ip.namespaceFieldSelector("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if selector != nil {
+		t.Errorf("no field selector needed for a single namespace, got %s", selector)
+	}
+}
+
+func TestNamespaceSelector(t *testing.T) {
+	ip := exampleIngress()
+	ip.namespaceCache = newResourceCache("namespaces", []string{api.NamespaceAll},
+		resourceSource{
+			func(namespace string, opts api.ListOptions) ([]runtime.Object, string, error) {
+				return []runtime.Object{&api.Namespace{ObjectMeta: api.ObjectMeta{Name: "team-a"}}}, "1", nil
+			},
+			func(namespace string, opts api.ListOptions) (watch.Interface, error) {
+				return watch.NewFake(), nil
+			},
+		},
+		onAnyChange(func() {}),
+	)
+	if err := ip.namespaceCache.sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !ip.namespaceAllowed("team-a") || ip.namespaceAllowed("default") {
+		t.Errorf("only namespaces matching the selector should be allowed")
+	}
+
+	ip.replaceIngresses(api.NamespaceAll, ip.ingresses())
+
+	r, _ := http.NewRequest("GET", "http://www.test.de/", nil)
+	if b := ip.routeRequestToBackend(r); b != nil {
+		t.Errorf("ingress of a namespace not matching the selector should not be routed, got %v", b)
+	}
+	if held := ip.rejectedIngresses(); len(held) != 0 {
+		t.Errorf("ingress of a namespace not matching the selector should not be held back, got %v", held)
+	}
+}
+
+func TestNamespaceScopedCaches(t *testing.T) {
+	ip := exampleIngress()
+	ip.IngressNamespaces = []string{api.NamespaceAll}
+	var lock sync.Mutex
+	selected := []string{"team-a"}
+	listed := []string{}
+
+	ip.namespaceCache = newResourceCache("namespaces", []string{api.NamespaceAll},
+		resourceSource{
+			func(namespace string, opts api.ListOptions) ([]runtime.Object, string, error) {
+				lock.Lock()
+				defer lock.Unlock()
+				objs := []runtime.Object{}
+				for _, name := range selected {
+					objs = append(objs, &api.Namespace{ObjectMeta: api.ObjectMeta{Name: name}})
+				}
+				return objs, "1", nil
+			},
+			func(namespace string, opts api.ListOptions) (watch.Interface, error) {
+				return watch.NewFake(), nil
+			},
+		},
+		onAnyChange(ip.followSelectedNamespaces),
+	)
+	newCache := func(kind string) *resourceCache {
+		return newResourceCache(kind, nil,
+			resourceSource{
+				func(namespace string, opts api.ListOptions) ([]runtime.Object, string, error) {
+					lock.Lock()
+					defer lock.Unlock()
+					listed = append(listed, kind+" in "+namespaceString(namespace))
+					return []runtime.Object{&api.Secret{ObjectMeta: api.ObjectMeta{Name: kind, Namespace: namespace}}}, "1", nil
+				},
+				func(namespace string, opts api.ListOptions) (watch.Interface, error) {
+					return watch.NewFake(), nil
+				},
+			},
+			onAnyChange(func() {}),
+		)
+	}
+	ip.serviceCache = newCache("services")
+	ip.endpointsCache = newCache("endpoints")
+	ip.secretCache = newCache("secrets")
+	ip.ingressCache = newCache("ingresses")
+
+	if err := ip.syncCaches(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ip.ingressCache.sync(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"services in namespace team-a", "endpoints in namespace team-a", "secrets in namespace team-a", "ingresses in namespace team-a"}
+	if !reflect.DeepEqual(listed, expected) {
+		t.Errorf("expected only the selected namespace to be listed, got %v", listed)
+	}
+	ip.runCaches()
+	ip.ingressCache.run()
+
+	// namespaces starting to match are watched, the objects of namespaces
+	// no longer matching are dropped
+	lock.Lock()
+	selected = []string{"team-b"}
+	lock.Unlock()
+	if err := ip.namespaceCache.sync(); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() bool {
+		_, ok := ip.secretCache.get("team-b", "secrets")
+		return ok
+	}, "secrets of a namespace starting to match not cached")
+	if _, ok := ip.secretCache.get("team-a", "secrets"); ok {
+		t.Error("secrets of a namespace no longer matching still cached")
+	}
+	eventually(t, func() bool {
+		_, ok := ip.ingressCache.get("team-b", "ingresses")
+		return ok
+	}, "ingresses of a namespace starting to match not watched")
+	if _, ok := ip.ingressCache.get("team-a", "ingresses"); ok {
+		t.Error("ingresses of a namespace no longer matching still cached")
+	}
+	if !ip.ready() {
+		t.Error("namespaces starting to match should not hold back serving")
+	}
+
+	// stop watching
+	lock.Lock()
+	selected = nil
+	lock.Unlock()
+	if err := ip.namespaceCache.sync(); err != nil {
+		t.Fatal(err)
+	}
+
+}
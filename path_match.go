@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Path types decide how the path of a rule is compared to the request path.
+// An exact path has to be equal to the request path. A prefix has to match
+// whole segments, '/backend' matches '/backend' and '/backend/foo' but not
+// '/backendfoo', a trailing '/' of the path is ignored. A string prefix only
+// has to be at the start of the request path, and regex paths are regular
+// expressions. An empty path is treated like '/'. Among the paths of all
+// rules of a host an exact match beats all others, otherwise the longest
+// match wins and the path of the ingress with higher precedence, or the
+// first path listed, wins a tie.
+const (
+	pathTypeExact        = "exact"
+	pathTypePrefix       = "prefix"
+	pathTypeStringPrefix = "string-prefix"
+	pathTypeRegex        = "regex"
+)
+
+var pathTypes = map[string]bool{
+	pathTypeExact:        true,
+	pathTypePrefix:       true,
+	pathTypeStringPrefix: true,
+	pathTypeRegex:        true,
+}
+
+func validatePathType(value string) error {
+	if !pathTypes[value] {
+		return fmt.Errorf("unknown path type '%s'", value)
+	}
+	return nil
+}
+
+// pathTypeFor returns the path type used for a path of the ingress
+func (s *ingressSettings) pathTypeFor(path string) string {
+	if pathType, ok := s.PathTypes[path]; ok {
+		return pathType
+	}
+	if s.UseRegex {
+		return pathTypeRegex
+	}
+	return s.PathType
+}
+
+// matchPath compares a path of a rule to the request path. It returns the
+// length of the matched part of the request path, which is 0 if the path
+// does not match.
+func (s *ingressSettings) matchPath(path, requestPath string) (length int, exact bool) {
+	pathType := s.pathTypeFor(path)
+	if pathType == pathTypeRegex {
+		if re, ok := s.pathRegexps[path]; ok {
+			if loc := re.FindStringIndex(requestPath); loc != nil {
+				return loc[1], false
+			}
+		}
+		return 0, false
+	}
+	if len(path) == 0 {
+		path = "/"
+	}
+
+	switch pathType {
+	case pathTypeExact:
+		if requestPath == path {
+			return len(path), true
+		}
+	case pathTypePrefix:
+		prefix := strings.TrimSuffix(path, "/")
+		if len(prefix) == 0 {
+			if strings.HasPrefix(requestPath, "/") {
+				return 1, false
+			}
+			return 0, false
+		}
+		if strings.HasPrefix(requestPath, prefix) && (len(requestPath) == len(prefix) || requestPath[len(prefix)] == '/') {
+			return len(prefix), false
+		}
+	default:
+		if strings.HasPrefix(requestPath, path) {
+			return len(path), false
+		}
+	}
+	return 0, false
+}
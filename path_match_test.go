@@ -0,0 +1,263 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/util/intstr"
+)
+
+func TestMatchPath(t *testing.T) {
+	for _, test := range []struct {
+		pathType    string
+		path        string
+		requestPath string
+		length      int
+		exact       bool
+	}{
+		{pathTypeExact, "/backend", "/backend", 8, true},
+		{pathTypeExact, "/backend", "/backend/", 0, false},
+		{pathTypeExact, "/backend", "/backendfoo", 0, false},
+		{pathTypeExact, "", "/", 1, true},
+		{pathTypeExact, "", "/foo", 0, false},
+
+		{pathTypePrefix, "/backend", "/backend", 8, false},
+		{pathTypePrefix, "/backend", "/backend/", 8, false},
+		{pathTypePrefix, "/backend", "/backend/foo", 8, false},
+		{pathTypePrefix, "/backend", "/backendfoo", 0, false},
+		{pathTypePrefix, "/backend/", "/backend", 8, false},
+		{pathTypePrefix, "/backend/", "/backend/foo", 8, false},
+		{pathTypePrefix, "/backend/", "/backendfoo", 0, false},
+		{pathTypePrefix, "/", "/anything", 1, false},
+		{pathTypePrefix, "", "/anything", 1, false},
+		{pathTypePrefix, "/a/b", "/a", 0, false},
+
+		{pathTypeStringPrefix, "/backend", "/backendfoo", 8, false},
+		{pathTypeStringPrefix, "/backend", "/backend/foo", 8, false},
+		{pathTypeStringPrefix, "/backend/", "/backend", 0, false},
+		{pathTypeStringPrefix, "", "/anything", 1, false},
+		{pathTypeStringPrefix, "/", "/anything", 1, false},
+
+		{pathTypeRegex, "/v[0-9]+", "/v12/foo", 4, false},
+		{pathTypeRegex, "/v[0-9]+", "/vx", 0, false},
+		{pathTypeRegex, "", "/anything", 1, false},
+	} {
+		settings := defaultIngressSettings(NewConfig())
+		settings.PathType = test.pathType
+		ing := &extensions.Ingress{
+			Spec: extensions.IngressSpec{
+				Rules: []extensions.IngressRule{exampleRule("", "service1")},
+			},
+		}
+		ing.Spec.Rules[0].HTTP.Paths[0].Path = test.path
+		if problems := settings.compilePaths(ing); len(problems) != 0 {
+			t.Fatalf("unexpected problems %v", problems)
+		}
+
+		length, exact := settings.matchPath(test.path, test.requestPath)
+		if length != test.length || exact != test.exact {
+			t.Errorf("%s path '%s' matched '%s' with length=%d exact=%t, expected %d %t",
+				test.pathType, test.path, test.requestPath, length, exact, test.length, test.exact)
+		}
+	}
+}
+
+func TestPathTypeRouting(t *testing.T) {
+	paths := []struct {
+		path    string
+		service string
+	}{
+		{"", "empty"},
+		{"/", "root"},
+		{"/backend", "backend-first"},
+		{"/backend", "backend-second"},
+		{"/static", "static"},
+		{"/static/", "static-slash"},
+	}
+	rule := exampleRule("www.test.de", "unused")
+	rule.HTTP.Paths = nil
+	for _, path := range paths {
+		rule.HTTP.Paths = append(rule.HTTP.Paths, extensions.HTTPIngressPath{
+			Path: path.path,
+			Backend: extensions.IngressBackend{
+				ServiceName: path.service,
+				ServicePort: intstr.FromInt(8080),
+			},
+		})
+	}
+
+	ing := &extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "ingress1",
+			Namespace: "default",
+			Annotations: map[string]string{
+				annotationPrefix + "path-type":  pathTypePrefix,
+				annotationPrefix + "path-types": "/backend=exact, /static/=string-prefix",
+			},
+		},
+		Spec: extensions.IngressSpec{
+			Rules: []extensions.IngressRule{rule},
+		},
+	}
+	if _, problems := parseIngressSettings(ing, NewConfig()); len(problems) != 0 {
+		t.Fatalf("unexpected problems %v", problems)
+	}
+
+	i := NewIngressProxy()
+	i.SetIngresses([]*extensions.Ingress{ing})
+
+	for _, test := range []struct {
+		path    string
+		service string
+		matched string
+	}{
+		// the empty path ties with '/' and is listed first
+		{"/", "empty", "/"},
+		{"/other", "empty", "/"},
+		// both '/backend' paths are exact by the path types annotation
+		{"/backend", "backend-first", "/backend"},
+		{"/backend/foo", "empty", "/"},
+		{"/backendfoo", "empty", "/"},
+		{"/static", "static", "/static"},
+		{"/staticfoo", "empty", "/"},
+		// the string prefix '/static/' matches longer than the prefix
+		{"/static/foo", "static-slash", "/static/"},
+	} {
+		r := http.Request{}
+		r.Host = "www.test.de"
+		r.URL = &url.URL{Path: test.path}
+		b := i.routeRequestToBackend(&r)
+		if b == nil || b.ServiceName != test.service || b.Matched != test.matched {
+			t.Errorf("request for path=%s routed to wrong backend=%+v, expected %s matching %s", test.path, b, test.service, test.matched)
+		}
+	}
+
+	// an exact match beats a prefix listed before it
+	ing.Annotations[annotationPrefix+"path-types"] = "/=exact"
+	i.SetIngresses([]*extensions.Ingress{ing})
+	r := http.Request{Host: "www.test.de", URL: &url.URL{Path: "/"}}
+	if b := i.routeRequestToBackend(&r); b == nil || b.ServiceName != "root" {
+		t.Errorf("request for path=/ routed to wrong backend=%+v, expected root", b)
+	}
+
+	for _, value := range []string{"/backend=glob", "/backend", ""} {
+		ing.Annotations[annotationPrefix+"path-types"] = value
+		if _, problems := parseIngressSettings(ing, NewConfig()); len(problems) != 1 || problems[0].Reason != reasonInvalidAnnotation {
+			t.Errorf("expected an invalid annotation for path types '%s', got %v", value, problems)
+		}
+	}
+}
+
+func TestPathTypesWithCommas(t *testing.T) {
+	rule := exampleRule("www.test.de", "unused")
+	rule.HTTP.Paths = nil
+	for _, path := range []string{"/v[0-9]{1,2}/api", "/a,b"} {
+		rule.HTTP.Paths = append(rule.HTTP.Paths, extensions.HTTPIngressPath{
+			Path: path,
+			Backend: extensions.IngressBackend{
+				ServiceName: "service1",
+				ServicePort: intstr.FromInt(8080),
+			},
+		})
+	}
+	ing := &extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "ingress1",
+			Namespace: "default",
+			Annotations: map[string]string{
+				annotationPrefix + "path-types": "/v[0-9]{1,2}/api=regex, /a,b=exact",
+			},
+		},
+		Spec: extensions.IngressSpec{
+			Rules: []extensions.IngressRule{rule},
+		},
+	}
+
+	settings, problems := parseIngressSettings(ing, NewConfig())
+	if len(problems) != 0 {
+		t.Fatalf("unexpected problems %v", problems)
+	}
+	if len(settings.PathTypes) != 2 || settings.PathTypes["/v[0-9]{1,2}/api"] != pathTypeRegex || settings.PathTypes["/a,b"] != pathTypeExact {
+		t.Errorf("unexpected path types %v", settings.PathTypes)
+	}
+
+	i := NewIngressProxy()
+	i.SetIngresses([]*extensions.Ingress{ing})
+	for _, test := range []struct {
+		path    string
+		matched string
+	}{
+		{"/v12/api", "/v12/api"},
+		{"/a,b", "/a,b"},
+	} {
+		r := http.Request{Host: "www.test.de", URL: &url.URL{Path: test.path}}
+		if b := i.routeRequestToBackend(&r); b == nil || b.Matched != test.matched {
+			t.Errorf("request for path=%s routed to wrong backend=%+v, expected a match of %s", test.path, b, test.matched)
+		}
+	}
+
+	ing.Annotations[annotationPrefix+"path-types"] = "/v[0-9]{1,2}/api=regx, /a,b=exact"
+	if _, problems := parseIngressSettings(ing, NewConfig()); len(problems) != 1 || problems[0].Reason != reasonInvalidAnnotation {
+		t.Errorf("expected an invalid annotation for a misspelled path type, got %v", problems)
+	}
+}
+
+func TestPlainPathsWithRegexMetacharacters(t *testing.T) {
+	rule := exampleRule("www.test.de", "unused")
+	rule.HTTP.Paths = nil
+	for _, path := range []string{"/docs/[draft", "/a(b", "/c.d"} {
+		rule.HTTP.Paths = append(rule.HTTP.Paths, extensions.HTTPIngressPath{
+			Path: path,
+			Backend: extensions.IngressBackend{
+				ServiceName: "service1",
+				ServicePort: intstr.FromInt(8080),
+			},
+		})
+	}
+	ing := &extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "ingress1",
+			Namespace: "default",
+			Annotations: map[string]string{
+				annotationPrefix + "path-types": "/a(b=prefix, /c.d=exact",
+			},
+		},
+		Spec: extensions.IngressSpec{
+			Rules: []extensions.IngressRule{rule},
+		},
+	}
+
+	i := NewIngressProxy()
+	if problems := i.validateIngress(ing); len(problems) != 0 {
+		t.Fatalf("expected no problems for paths which are not regex paths, got %v", problems)
+	}
+	i.SetIngresses([]*extensions.Ingress{ing})
+
+	for _, test := range []struct {
+		path    string
+		matched string
+	}{
+		{"/docs/[draft-1", "/docs/[draft"},
+		{"/a(b/c", "/a(b"},
+		{"/c.d", "/c.d"},
+	} {
+		r := http.Request{Host: "www.test.de", URL: &url.URL{Path: test.path}}
+		if b := i.routeRequestToBackend(&r); b == nil || b.Matched != test.matched {
+			t.Errorf("request for path=%s routed to wrong backend=%+v, expected a match of %s", test.path, b, test.matched)
+		}
+	}
+	for _, path := range []string{"/docs/draft", "/ab", "/cxd"} {
+		r := http.Request{Host: "www.test.de", URL: &url.URL{Path: path}}
+		if b := i.routeRequestToBackend(&r); b != nil {
+			t.Errorf("request for path=%s should not match, got backend=%+v", path, b)
+		}
+	}
+
+	ing.Annotations[annotationPrefix+"path-types"] = "/docs/[draft=regex"
+	if problems := i.validateIngress(ing); len(problems) != 1 || problems[0].Reason != reasonInvalidPath {
+		t.Errorf("expected an invalid path for the regex path, got %v", problems)
+	}
+}
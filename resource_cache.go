@@ -0,0 +1,658 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"k8s.io/kubernetes/pkg/api"
+	apierrors "k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/api/meta"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/util/wait"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+// rebuildKey is the only item of the rebuild queue, so any number of changes
+// results in a single rebuild
+const rebuildKey = "routing"
+
+// resourceLister lists the objects of a namespace, together with the resource
+// version of the list
+type resourceLister func(namespace string, opts api.ListOptions) ([]runtime.Object, string, error)
+
+// resourceWatcher watches the objects of a namespace
+type resourceWatcher func(namespace string, opts api.ListOptions) (watch.Interface, error)
+
+// resourceSource lists and watches one kind of object
+type resourceSource struct {
+	list  resourceLister
+	watch resourceWatcher
+}
+
+// clientSource lists and watches one kind of object through the calls of its
+// client, options adds selectors to both
+func clientSource(listCall func(namespace string, opts api.ListOptions) (runtime.Object, error), watchCall resourceWatcher, options func(namespace string, opts *api.ListOptions) error) resourceSource {
+	return resourceSource{
+		list: func(namespace string, opts api.ListOptions) ([]runtime.Object, string, error) {
+			if err := options(namespace, &opts); err != nil {
+				return nil, "", err
+			}
+			result, err := listCall(namespace, opts)
+			if err != nil {
+				return nil, "", err
+			}
+			objs, err := meta.ExtractList(result)
+			if err != nil {
+				return nil, "", err
+			}
+			listMeta, err := api.ListMetaFor(result)
+			if err != nil {
+				return nil, "", err
+			}
+			return objs, listMeta.ResourceVersion, nil
+		},
+		watch: func(namespace string, opts api.ListOptions) (watch.Interface, error) {
+			if err := options(namespace, &opts); err != nil {
+				return nil, err
+			}
+			return watchCall(namespace, opts)
+		},
+	}
+}
+
+// resourceHandler is told about the changes of a cache, the cache's lock is
+// not held while it is called
+type resourceHandler struct {
+	// replaced gets all objects of a namespace once it has been listed, and
+	// none once it is no longer watched
+	replaced func(namespace string, objs []runtime.Object)
+	// changed gets an object added, modified or deleted by a watch
+	changed func(eventType watch.EventType, obj runtime.Object)
+}
+
+// onAnyChange returns a handler calling f for every change
+func onAnyChange(f func()) resourceHandler {
+	return resourceHandler{
+		replaced: func(string, []runtime.Object) { f() },
+		changed:  func(watch.EventType, runtime.Object) { f() },
+	}
+}
+
+// resourceCache keeps a local copy of one kind of object in the watched
+// namespaces, indexed by namespace/name. It is updated through list and
+// watch, every change is passed on to the handler. The namespaces can be
+// changed while the cache runs, objects of namespaces no longer watched are
+// dropped.
+type resourceCache struct {
+	kind    string
+	source  resourceSource
+	handler resourceHandler
+
+	lock       sync.RWMutex
+	namespaces []string
+	items      map[string]runtime.Object
+
+	// synced is the resource version of the last list by namespace, watching
+	// starts from it
+	synced map[string]string
+
+	// stops ends watching a namespace, it is nil until the cache runs
+	stops map[string]chan struct{}
+
+	// ready stays set once the namespaces have been listed, namespaces
+	// added later don't hold back serving
+	ready bool
+}
+
+func newResourceCache(kind string, namespaces []string, source resourceSource, handler resourceHandler) *resourceCache {
+	return &resourceCache{
+		kind:       kind,
+		namespaces: namespaces,
+		source:     source,
+		handler:    handler,
+		items:      make(map[string]runtime.Object),
+		synced:     make(map[string]string),
+	}
+}
+
+func objectKey(obj runtime.Object) (string, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return "", err
+	}
+	return accessor.GetNamespace() + "/" + accessor.GetName(), nil
+}
+
+// objects returns all cached objects
+func (c *resourceCache) objects() []runtime.Object {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	objs := make([]runtime.Object, 0, len(c.items))
+	for _, obj := range c.items {
+		objs = append(objs, obj)
+	}
+	return objs
+}
+
+// get returns the cached object by namespace and name
+func (c *resourceCache) get(namespace, name string) (runtime.Object, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	obj, ok := c.items[namespace+"/"+name]
+	return obj, ok
+}
+
+// hasSynced is true once all namespaces have been listed
+func (c *resourceCache) hasSynced() bool {
+	c.lock.RLock()
+	ready := c.ready
+	c.lock.RUnlock()
+	if ready {
+		return true
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, namespace := range c.namespaces {
+		if _, ok := c.synced[namespace]; !ok {
+			return false
+		}
+	}
+	c.ready = true
+	return true
+}
+
+// watching checks if a namespace is watched, the lock has to be held by the
+// caller
+func (c *resourceCache) watching(namespace string) bool {
+	for _, watched := range c.namespaces {
+		if watched == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// watches checks if a namespace is still followed by the cache
+func (c *resourceCache) watches(namespace string) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.watching(namespace)
+}
+
+// setNamespaces changes the watched namespaces. Namespaces added are watched
+// right away if the cache runs, otherwise they are listed by sync.
+func (c *resourceCache) setNamespaces(namespaces []string) {
+	c.lock.Lock()
+	previous := c.namespaces
+	c.namespaces = namespaces
+
+	dropped := []string{}
+	for _, namespace := range previous {
+		if c.watching(namespace) {
+			continue
+		}
+		if stop, ok := c.stops[namespace]; ok {
+			close(stop)
+			delete(c.stops, namespace)
+		}
+		for key, obj := range c.items {
+			if objectInNamespace(obj, namespace) {
+				delete(c.items, key)
+			}
+		}
+		delete(c.synced, namespace)
+		dropped = append(dropped, namespace)
+	}
+	if c.stops != nil {
+		for _, namespace := range namespaces {
+			if _, ok := c.stops[namespace]; !ok {
+				c.runNamespace(namespace)
+			}
+		}
+	}
+	c.lock.Unlock()
+
+	for _, namespace := range dropped {
+		c.handler.replaced(namespace, nil)
+	}
+}
+
+// sync lists all namespaces once, it returns on the first error
+func (c *resourceCache) sync() error {
+	c.lock.RLock()
+	namespaces := c.namespaces
+	c.lock.RUnlock()
+
+	for _, namespace := range namespaces {
+		if _, err := c.listNamespace(namespace); err != nil {
+			return fmt.Errorf("Error listing %s in %s: %s", c.kind, namespaceString(namespace), err)
+		}
+	}
+	return nil
+}
+
+// run keeps all namespaces up to date without blocking
+func (c *resourceCache) run() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.stops = make(map[string]chan struct{})
+	for _, namespace := range c.namespaces {
+		c.runNamespace(namespace)
+	}
+}
+
+// runNamespace starts watching a namespace until it is stopped, the lock
+// has to be held by the caller
+func (c *resourceCache) runNamespace(namespace string) {
+	stop := make(chan struct{})
+	c.stops[namespace] = stop
+	go c.followNamespace(namespace, stop)
+}
+
+// followNamespace re-lists when the resource version expires and reconnects
+// with an exponential backoff after errors and watches closing right away,
+// the cached objects are kept meanwhile
+func (c *resourceCache) followNamespace(namespace string, stop <-chan struct{}) {
+	c.lock.RLock()
+	resourceVersion := c.synced[namespace]
+	c.lock.RUnlock()
+
+	backoff := watchBackoffMin
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		if resourceVersion == "" {
+			var err error
+			resourceVersion, err = c.listNamespace(namespace)
+			if err != nil {
+				log.Warnf("Listing %s in %s failed, retrying in %s: %s", c.kind, namespaceString(namespace), backoff, err)
+				sleepUnlessStopped(backoff, stop)
+				backoff = nextWatchBackoff(backoff)
+				continue
+			}
+		}
+
+		started := time.Now()
+		watchedVersion := resourceVersion
+		var err error
+		resourceVersion, err = c.watchNamespace(namespace, resourceVersion, stop)
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if err == nil && resourceVersion == watchedVersion && time.Since(started) < watchBackoffMin {
+			err = errWatchClosed
+		}
+		switch {
+		case err == errWatchExpired:
+			log.Infof("Watch of %s in %s expired, re-listing", c.kind, namespaceString(namespace))
+			resourceVersion = ""
+		case err != nil:
+			log.Warnf("Watching %s in %s failed, retrying in %s: %s", c.kind, namespaceString(namespace), backoff, err)
+			sleepUnlessStopped(backoff, stop)
+			backoff = nextWatchBackoff(backoff)
+		default:
+			backoff = watchBackoffMin
+		}
+	}
+}
+
+func sleepUnlessStopped(d time.Duration, stop <-chan struct{}) {
+	select {
+	case <-time.After(d):
+	case <-stop:
+	}
+}
+
+// listNamespace replaces the cached objects of a namespace
+func (c *resourceCache) listNamespace(namespace string) (string, error) {
+	objs, resourceVersion, err := c.source.list(namespace, api.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	items := make(map[string]runtime.Object, len(objs))
+	for _, obj := range objs {
+		key, err := objectKey(obj)
+		if err != nil {
+			return "", err
+		}
+		items[key] = obj
+	}
+
+	c.lock.Lock()
+	if !c.watching(namespace) {
+		// the namespace was dropped while it was listed
+		c.lock.Unlock()
+		return resourceVersion, nil
+	}
+	for key, obj := range c.items {
+		if objectInNamespace(obj, namespace) {
+			delete(c.items, key)
+		}
+	}
+	for key, obj := range items {
+		c.items[key] = obj
+	}
+	c.synced[namespace] = resourceVersion
+	c.lock.Unlock()
+
+	c.handler.replaced(namespace, objs)
+	return resourceVersion, nil
+}
+
+// watchNamespace applies changes until the watch is closed, fails or is
+// stopped and returns the last seen resource version
+func (c *resourceCache) watchNamespace(namespace, resourceVersion string, stop <-chan struct{}) (string, error) {
+	timeout := int64(wait.Jitter(watchTimeout, 0.1).Seconds())
+	watcher, err := c.source.watch(namespace, api.ListOptions{
+		ResourceVersion: resourceVersion,
+		TimeoutSeconds:  &timeout,
+	})
+	if err != nil {
+		return resourceVersion, err
+	}
+	defer watcher.Stop()
+
+	for {
+		var event watch.Event
+		select {
+		case <-stop:
+			return resourceVersion, nil
+		case e, ok := <-watcher.ResultChan():
+			if !ok {
+				return resourceVersion, nil
+			}
+			event = e
+		}
+
+		if event.Type == watch.Error {
+			if status, ok := event.Object.(*unversioned.Status); ok && status.Code == http.StatusGone {
+				return resourceVersion, errWatchExpired
+			}
+			return resourceVersion, apierrors.FromObject(event.Object)
+		}
+
+		accessor, err := meta.Accessor(event.Object)
+		if err != nil {
+			return resourceVersion, err
+		}
+		resourceVersion = accessor.GetResourceVersion()
+		key := accessor.GetNamespace() + "/" + accessor.GetName()
+
+		c.lock.Lock()
+		if !c.watching(namespace) {
+			c.lock.Unlock()
+			return resourceVersion, nil
+		}
+		switch event.Type {
+		case watch.Added, watch.Modified:
+			c.items[key] = event.Object
+		case watch.Deleted:
+			delete(c.items, key)
+		}
+		c.lock.Unlock()
+
+		c.handler.changed(event.Type, event.Object)
+	}
+}
+
+func objectInNamespace(obj runtime.Object, namespace string) bool {
+	if namespace == api.NamespaceAll {
+		return true
+	}
+	accessor, err := meta.Accessor(obj)
+	return err == nil && accessor.GetNamespace() == namespace
+}
+
+func namespaceString(namespace string) string {
+	if namespace == api.NamespaceAll {
+		return "all namespaces"
+	}
+	return "namespace " + namespace
+}
+
+// setupCaches creates the caches for services, endpoints and secrets of the
+// watched namespaces. With a namespace selector they start without
+// namespaces and follow the namespaces matching the selector.
+func (ip *IngressProxy) setupCaches() {
+	client := ip.kubeClient
+	namespaces := ip.IngressNamespaces
+	if ip.namespaceCache != nil {
+		namespaces = ip.selectedNamespaces()
+	}
+	ip.serviceCache = ip.newNamespacedCache("services", namespaces,
+		func(namespace string, opts api.ListOptions) (runtime.Object, error) {
+			return client.Services(namespace).List(opts)
+		},
+		func(namespace string, opts api.ListOptions) (watch.Interface, error) {
+			return client.Services(namespace).Watch(opts)
+		},
+	)
+	ip.endpointsCache = ip.newNamespacedCache("endpoints", namespaces,
+		func(namespace string, opts api.ListOptions) (runtime.Object, error) {
+			return client.Endpoints(namespace).List(opts)
+		},
+		func(namespace string, opts api.ListOptions) (watch.Interface, error) {
+			return client.Endpoints(namespace).Watch(opts)
+		},
+	)
+	ip.secretCache = ip.newNamespacedCache("secrets", namespaces,
+		func(namespace string, opts api.ListOptions) (runtime.Object, error) {
+			return client.Secrets(namespace).List(opts)
+		},
+		func(namespace string, opts api.ListOptions) (watch.Interface, error) {
+			return client.Secrets(namespace).Watch(opts)
+		},
+	)
+}
+
+// newNamespacedCache creates a cache of objects the routing depends on,
+// excluded namespaces are left out
+func (ip *IngressProxy) newNamespacedCache(kind string, namespaces []string, listCall func(namespace string, opts api.ListOptions) (runtime.Object, error), watchCall resourceWatcher) *resourceCache {
+	options := func(namespace string, opts *api.ListOptions) error {
+		fieldSelector, err := ip.namespaceFieldSelector(namespace)
+		opts.FieldSelector = fieldSelector
+		return err
+	}
+	return newResourceCache(kind, namespaces, clientSource(listCall, watchCall, options), ip.rebuildOnReference(kind))
+}
+
+// objectReferences are the services and secrets ingresses refer to, keyed by
+// kind and namespace/name. Endpoints are referred to through their service.
+type objectReferences map[string]bool
+
+func referenceKey(kind, namespace, name string) string {
+	if kind == "endpoints" {
+		kind = "services"
+	}
+	return kind + "/" + namespace + "/" + name
+}
+
+// addIngress adds the services and secrets an ingress refers to
+func (r objectReferences) addIngress(ing *extensions.Ingress) {
+	for _, backend := range ingressBackends(ing) {
+		r[referenceKey("services", ing.Namespace, backend.ServiceName)] = true
+	}
+	for _, t := range ing.Spec.TLS {
+		if len(t.SecretName) > 0 {
+			r[referenceKey("secrets", ing.Namespace, t.SecretName)] = true
+		}
+	}
+	if name := authSecretName(ing); len(name) > 0 {
+		r[referenceKey("secrets", ing.Namespace, name)] = true
+	}
+}
+
+// refersTo checks if a served ingress, or one held back until it is valid,
+// refers to an object
+func (ip *IngressProxy) refersTo(kind string, obj runtime.Object) bool {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return true
+	}
+	key := referenceKey(kind, accessor.GetNamespace(), accessor.GetName())
+	if ip.currentSnapshot().references[key] {
+		return true
+	}
+
+	held := objectReferences{}
+	for _, ing := range ip.rejectedIngresses() {
+		held.addIngress(ing)
+	}
+	return held[key]
+}
+
+// rebuildOnReference returns a handler queueing a rebuild for changes of
+// objects ingresses refer to, other objects of the watched namespaces don't
+// affect the routing. Lists are rare, they always result in a rebuild.
+func (ip *IngressProxy) rebuildOnReference(kind string) resourceHandler {
+	return resourceHandler{
+		replaced: func(string, []runtime.Object) {
+			ip.queueRebuild()
+		},
+		changed: func(eventType watch.EventType, obj runtime.Object) {
+			if ip.refersTo(kind, obj) {
+				ip.queueRebuild()
+			}
+		},
+	}
+}
+
+func (ip *IngressProxy) caches() []*resourceCache {
+	caches := []*resourceCache{}
+	if ip.namespaceCache != nil {
+		caches = append(caches, ip.namespaceCache)
+	}
+	if ip.serviceCache != nil {
+		caches = append(caches, ip.serviceCache, ip.endpointsCache, ip.secretCache)
+	}
+	return caches
+}
+
+// syncCaches fills all caches before anything is served
+func (ip *IngressProxy) syncCaches() error {
+	for _, c := range ip.caches() {
+		if err := c.sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runCaches keeps all caches up to date
+func (ip *IngressProxy) runCaches() {
+	for _, c := range ip.caches() {
+		go c.run()
+	}
+}
+
+// ready is the gate for serving requests, it opens once all caches have
+// been synced
+func (ip *IngressProxy) ready() bool {
+	for _, c := range ip.caches() {
+		if !c.hasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// getService returns a service from the cache, or from the API server
+// without caches
+func (ip *IngressProxy) getService(namespace, name string) (*api.Service, error) {
+	if ip.serviceCache == nil {
+		return ip.kubeClient.Services(namespace).Get(name)
+	}
+	if obj, ok := ip.serviceCache.get(namespace, name); ok {
+		return obj.(*api.Service), nil
+	}
+	return nil, apierrors.NewNotFound(api.Resource("services"), name)
+}
+
+// getEndpoints returns endpoints from the cache, or from the API server
+// without caches
+func (ip *IngressProxy) getEndpoints(namespace, name string) (*api.Endpoints, error) {
+	if ip.endpointsCache == nil {
+		return ip.kubeClient.Endpoints(namespace).Get(name)
+	}
+	if obj, ok := ip.endpointsCache.get(namespace, name); ok {
+		return obj.(*api.Endpoints), nil
+	}
+	return nil, apierrors.NewNotFound(api.Resource("endpoints"), name)
+}
+
+// getSecret returns a secret from the cache, or from the API server without
+// caches
+func (ip *IngressProxy) getSecret(namespace, name string) (*api.Secret, error) {
+	if ip.secretCache == nil {
+		return ip.kubeClient.Secrets(namespace).Get(name)
+	}
+	if obj, ok := ip.secretCache.get(namespace, name); ok {
+		return obj.(*api.Secret), nil
+	}
+	return nil, apierrors.NewNotFound(api.Resource("secrets"), name)
+}
+
+// queueRebuild schedules a rebuild of the routing, changes arriving before
+// the rebuild starts are coalesced into it
+func (ip *IngressProxy) queueRebuild() {
+	ip.rebuildQueue.Add(rebuildKey)
+}
+
+// runRebuilds rebuilds the routing from the stored ingresses and the caches.
+// Rejected ingresses are validated again, they might refer to services or
+// secrets which exist or be in a namespace which is served by now.
+func (ip *IngressProxy) runRebuilds() {
+	for {
+		item, shutdown := ip.rebuildQueue.Get()
+		if shutdown {
+			return
+		}
+
+		valid := []*extensions.Ingress{}
+		for _, ing := range ip.rejectedIngresses() {
+			if claimed, _ := ip.claimsIngress(ing); claimed && ip.namespaceAllowed(ing.Namespace) && len(ip.validateIngress(ing)) == 0 {
+				valid = append(valid, ing)
+			}
+		}
+
+		ip.ingressStoreLock.Lock()
+		for _, ing := range valid {
+			ip.storeRejectedIngress(ing)
+		}
+		ip.applyIngressStore()
+		ip.ingressStoreLock.Unlock()
+
+		ip.rebuildQueue.Done(item)
+	}
+}
+
+// storeRejectedIngress stores a rejected ingress which turned out valid,
+// unless it has been deleted or another version came in while it was
+// validated. The store lock has to be held by the caller.
+func (ip *IngressProxy) storeRejectedIngress(ing *extensions.Ingress) {
+	key := ingressKey(ing)
+	ip.rejectedLock.Lock()
+	current, ok := ip.rejected[key]
+	ip.rejectedLock.Unlock()
+	if !ok || current.ResourceVersion != ing.ResourceVersion {
+		return
+	}
+
+	log.Infof("Rejected ingress %s is valid now", key)
+	ip.acceptIngress(ing)
+	ip.ingressStore[key] = ing
+	ip.queueIngressCheck(ing)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/fields"
+	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+func exampleService(namespace, name, resourceVersion string) *api.Service {
+	return &api.Service{
+		ObjectMeta: api.ObjectMeta{
+			Name:            name,
+			Namespace:       namespace,
+			ResourceVersion: resourceVersion,
+		},
+	}
+}
+
+func TestResourceCache(t *testing.T) {
+	fake := watch.NewFake()
+	changes := 0
+
+	c := newResourceCache("services", []string{"default"},
+		resourceSource{
+			func(namespace string, opts api.ListOptions) ([]runtime.Object, string, error) {
+				return []runtime.Object{exampleService(namespace, "service1", "1")}, "1", nil
+			},
+			func(namespace string, opts api.ListOptions) (watch.Interface, error) {
+				if opts.ResourceVersion != "1" {
+					t.Errorf("expected watch from resourceVersion=1, got %s", opts.ResourceVersion)
+				}
+				return fake, nil
+			},
+		},
+		onAnyChange(func() { changes++ }),
+	)
+
+	if c.hasSynced() {
+		t.Fatalf("cache should not be synced before the first list")
+	}
+	if err := c.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if !c.hasSynced() {
+		t.Fatalf("cache should be synced after the first list")
+	}
+	if _, ok := c.get("default", "service1"); !ok {
+		t.Errorf("listed service1 should be cached")
+	}
+
+	done := make(chan string)
+	go func() {
+		resourceVersion, err := c.watchNamespace("default", "1", nil)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- resourceVersion
+	}()
+	fake.Add(exampleService("default", "service2", "2"))
+	fake.Delete(exampleService("default", "service1", "3"))
+	fake.Stop()
+
+	if resourceVersion := <-done; resourceVersion != "3" {
+		t.Errorf("expected resourceVersion=3 after the watch, got %s", resourceVersion)
+	}
+	if _, ok := c.get("default", "service2"); !ok {
+		t.Errorf("added service2 should be cached")
+	}
+	if _, ok := c.get("default", "service1"); ok {
+		t.Errorf("deleted service1 should not be cached")
+	}
+	if changes != 3 {
+		t.Errorf("expected 3 changes to be announced, got %d", changes)
+	}
+}
+
+func TestResourceCacheDroppedNamespace(t *testing.T) {
+	var c *resourceCache
+	replaced := []string{}
+	c = newResourceCache("services", []string{"team-a"},
+		resourceSource{
+			func(namespace string, opts api.ListOptions) ([]runtime.Object, string, error) {
+				return nil, "1", nil
+			},
+			func(namespace string, opts api.ListOptions) (watch.Interface, error) {
+				return watch.NewFake(), nil
+			},
+		},
+		resourceHandler{
+			replaced: func(namespace string, objs []runtime.Object) {
+				replaced = append(replaced, fmt.Sprintf("%s watched=%t", namespace, c.watches(namespace)))
+			},
+			changed: func(watch.EventType, runtime.Object) {},
+		},
+	)
+	if err := c.sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the handler can tell an empty namespace from a dropped one
+	c.setNamespaces([]string{"team-b"})
+	if expected := []string{"team-a watched=true", "team-a watched=false"}; !reflect.DeepEqual(replaced, expected) {
+		t.Errorf("expected %v, got %v", expected, replaced)
+	}
+}
+
+func TestStoreRejectedIngress(t *testing.T) {
+	ip := exampleIngress()
+	v1 := ip.ingresses()[0]
+	v1.ResourceVersion = "1"
+	v2 := *v1
+	v2.ResourceVersion = "2"
+	key := ingressKey(v1)
+
+	storeRejected := func(ing *extensions.Ingress) {
+		ip.ingressStoreLock.Lock()
+		ip.storeRejectedIngress(ing)
+		ip.ingressStoreLock.Unlock()
+	}
+
+	// an ingress deleted while it was validated stays deleted
+	ip.holdIngress(v1)
+	ip.deleteIngress(v1)
+	storeRejected(v1)
+	if stored := ip.storedIngress(key); stored != nil {
+		t.Errorf("deleted ingress stored again: %v", stored)
+	}
+
+	// a newer version rejected meanwhile is not overwritten
+	ip.holdIngress(&v2)
+	storeRejected(v1)
+	if stored := ip.storedIngress(key); stored != nil {
+		t.Errorf("stale version stored: %v", stored)
+	}
+
+	storeRejected(&v2)
+	if stored := ip.storedIngress(key); stored != &v2 || len(ip.rejectedIngresses()) != 0 {
+		t.Errorf("expected the rejected version to be stored, got %v", stored)
+	}
+}
+
+func TestClientSource(t *testing.T) {
+	fake := watch.NewFake()
+	source := clientSource(
+		func(namespace string, opts api.ListOptions) (runtime.Object, error) {
+			if opts.FieldSelector.String() != "metadata.name=service1" {
+				t.Errorf("expected the options to be applied to the list, got %s", opts.FieldSelector)
+			}
+			list := &api.ServiceList{Items: []api.Service{*exampleService(namespace, "service1", "1")}}
+			list.ResourceVersion = "2"
+			return list, nil
+		},
+		func(namespace string, opts api.ListOptions) (watch.Interface, error) {
+			if opts.FieldSelector.String() != "metadata.name=service1" {
+				t.Errorf("expected the options to be applied to the watch, got %s", opts.FieldSelector)
+			}
+			return fake, nil
+		},
+		func(namespace string, opts *api.ListOptions) error {
+			opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", "service1")
+			return nil
+		},
+	)
+
+	objs, resourceVersion, err := source.list("default", api.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 1 || objs[0].(*api.Service).Name != "service1" || resourceVersion != "2" {
+		t.Errorf("unexpected list %v at resourceVersion=%s", objs, resourceVersion)
+	}
+	if w, err := source.watch("default", api.ListOptions{}); err != nil || w != fake {
+		t.Errorf("expected the watch of the client, got %v %v", w, err)
+	}
+}
+
+func TestRebuildOnReference(t *testing.T) {
+	ip := exampleIngress()
+	drain := func() int {
+		queued := ip.rebuildQueue.Len()
+		for ip.rebuildQueue.Len() > 0 {
+			item, _ := ip.rebuildQueue.Get()
+			ip.rebuildQueue.Done(item)
+		}
+		return queued
+	}
+
+	endpoints := ip.rebuildOnReference("endpoints")
+	endpoints.changed(watch.Modified, &api.Endpoints{ObjectMeta: api.ObjectMeta{Name: "leader-lock", Namespace: "default"}})
+	if queued := drain(); queued != 0 {
+		t.Errorf("endpoints no ingress refers to should not queue a rebuild")
+	}
+	endpoints.changed(watch.Modified, &api.Endpoints{ObjectMeta: api.ObjectMeta{Name: "service2", Namespace: "default"}})
+	if queued := drain(); queued != 1 {
+		t.Errorf("endpoints of a served service should queue a rebuild")
+	}
+	endpoints.replaced("default", nil)
+	if queued := drain(); queued != 1 {
+		t.Errorf("a list should queue a rebuild")
+	}
+
+	// a held back ingress waits for its secret
+	held := &extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{Name: "ingress2", Namespace: "default"},
+		Spec: extensions.IngressSpec{
+			TLS: []extensions.IngressTLS{{Hosts: []string{"www.test.de"}, SecretName: "tls"}},
+		},
+	}
+	secrets := ip.rebuildOnReference("secrets")
+	secret := &api.Secret{ObjectMeta: api.ObjectMeta{Name: "tls", Namespace: "default"}}
+	secrets.changed(watch.Added, secret)
+	if queued := drain(); queued != 0 {
+		t.Errorf("a secret no ingress refers to should not queue a rebuild")
+	}
+	ip.holdIngress(held)
+	secrets.changed(watch.Added, secret)
+	if queued := drain(); queued != 1 {
+		t.Errorf("the secret of a held back ingress should queue a rebuild")
+	}
+
+	// the status is only synced right away if the served ingresses change
+	<-ip.statusSyncCh
+	ip.SetIngresses(ip.ingresses())
+	select {
+	case <-ip.statusSyncCh:
+		t.Errorf("status sync triggered without a change of the served ingresses")
+	default:
+	}
+	ip.SetIngresses(nil)
+	select {
+	case <-ip.statusSyncCh:
+	default:
+		t.Errorf("status sync not triggered after the served ingresses changed")
+	}
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"strings"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// The routing table is compiled from the applied ingresses whenever they
+// change, so looking up a request neither walks all rules nor allocates.
+// Exact hosts are kept in a map, wildcard hosts in a tree of their labels
+// starting with the top level domain. Every host has a radix tree of its
+// paths, only regex paths are matched one by one.
+//
+// The lookup gives the same result as comparing every rule in the order of
+// the ingresses: the best matching host wins if one of its paths matches. An
+// exact host beats any wildcard, a wildcard with a longer suffix beats one
+// with a shorter suffix. A wildcard host has to start with '*.', it matches
+// any host ending in the suffix after the '*', including further subdomains.
+// A rule without a host matches every host, but only after all exact and
+// wildcard hosts.
+// The paths of all rules of this host are merged, across ingresses. An exact
+// match wins, otherwise the longest match, and only paths matching equally
+// well are decided by the order of their rules and their position within the
+// rule.
+
+// routingTable routes requests to the paths of ingress rules
+type routingTable struct {
+	hosts          map[string]*hostRoutes
+	wildcards      *wildcardNode
+	catchAll       *hostRoutes
+	defaultBackend *route
+
+	// all routes of the table, to set up their proxies
+	all []*route
+
+	// paths are the paths of all rules in the order they were added
+	paths []*pathRoute
+}
+
+// route is a path of an ingress rule or the default backend of an ingress
+type route struct {
+	Ingress  *extensions.Ingress
+	Backend  *extensions.IngressBackend
+	Path     string
+	Settings *ingressSettings
+	Proxy    *backendProxy
+}
+
+// ingressBackend returns the backend of a route for a request, matched is
+// the part of the request path matched by the route
+func (r *route) ingressBackend(matched string) *ingressBackend {
+	return &ingressBackend{
+		IngressBackend: r.Backend,
+		Namespace:      r.Ingress.Namespace,
+		Matched:        matched,
+		Settings:       r.Settings,
+		Proxy:          r.Proxy,
+	}
+}
+
+// pathRoute is a path as stored for a host
+type pathRoute struct {
+	*route
+
+	// host is the host of the rule as written, routes the paths of all rules
+	// of the host and key the path as stored in its radix tree, which is
+	// empty for regex paths
+	host   string
+	routes *hostRoutes
+	key    string
+
+	// rule is the position of the rule among the rules of the host, pos the
+	// position of the path within the rule, both only break ties
+	rule int
+	pos  int
+
+	exact   bool
+	segment bool
+	length  int
+}
+
+// matches checks the path stored at a node after consuming a part of the
+// request path
+func (p *pathRoute) matches(requestPath string, consumed int) bool {
+	switch {
+	case p.exact:
+		return consumed == len(requestPath)
+	case p.segment:
+		return consumed == len(requestPath) || requestPath[consumed] == '/'
+	}
+	return true
+}
+
+// routeMatch holds the best path found during a lookup
+type routeMatch struct {
+	path   *pathRoute
+	length int
+}
+
+// offer replaces the best path if a path is a better match
+func (m *routeMatch) offer(p *pathRoute, length int) {
+	if m.path != nil {
+		switch {
+		case p.exact != m.path.exact:
+			if !p.exact {
+				return
+			}
+		case length != m.length:
+			if length < m.length {
+				return
+			}
+		case p.rule != m.path.rule:
+			if p.rule > m.path.rule {
+				return
+			}
+		case p.pos > m.path.pos:
+			return
+		}
+	}
+	m.path = p
+	m.length = length
+}
+
+// hostRoutes are the paths of all rules of a host
+type hostRoutes struct {
+	rules   int
+	paths   *radixNode
+	regexps []*pathRoute
+}
+
+func newHostRoutes() *hostRoutes {
+	return &hostRoutes{paths: &radixNode{}}
+}
+
+// addRule adds the paths of a rule after all rules added before
+func (h *hostRoutes) addRule(t *routingTable, ing *extensions.Ingress, settings *ingressSettings, rule *extensions.IngressRule) {
+	for pos := range rule.HTTP.Paths {
+		path := &rule.HTTP.Paths[pos]
+		p := &pathRoute{
+			route: &route{
+				Ingress:  ing,
+				Backend:  &path.Backend,
+				Path:     path.Path,
+				Settings: settings,
+			},
+			host:   rule.Host,
+			routes: h,
+			rule:   h.rules,
+			pos:    pos,
+		}
+		t.all = append(t.all, p.route)
+		t.paths = append(t.paths, p)
+
+		key := path.Path
+		if len(key) == 0 {
+			key = "/"
+		}
+		switch settings.pathTypeFor(path.Path) {
+		case pathTypeRegex:
+			h.regexps = append(h.regexps, p)
+			continue
+		case pathTypeExact:
+			p.exact = true
+		case pathTypePrefix:
+			if trimmed := strings.TrimSuffix(key, "/"); len(trimmed) > 0 {
+				key = trimmed
+				p.segment = true
+			}
+		}
+		p.key = key
+		p.length = len(key)
+		h.paths.insert(key, p)
+	}
+	h.rules++
+}
+
+// lookup finds the best path for a request path
+func (h *hostRoutes) lookup(requestPath string) (*route, int) {
+	m := routeMatch{}
+	h.paths.lookup(requestPath, &m)
+	for _, p := range h.regexps {
+		if length, _ := p.Settings.matchPath(p.Path, requestPath); length > 0 {
+			m.offer(p, length)
+		}
+	}
+	if m.path == nil {
+		return nil, 0
+	}
+	return m.path.route, m.length
+}
+
+// shadowedBy returns the route a lookup selects instead of a path for every
+// request path the path matches, or nil if the path is selected for some
+// request path. Besides the path itself only request paths continuing it
+// with '/' or, for a string prefix, with any other character have to be
+// tried, other request paths can only match more paths. A regex path is
+// only known to be shadowed by a regex path with the same expression added
+// before.
+func (h *hostRoutes) shadowedBy(p *pathRoute) *route {
+	if len(p.key) == 0 {
+		for _, other := range h.regexps {
+			if other == p {
+				return nil
+			}
+			if other.Path == p.Path {
+				return other.route
+			}
+		}
+		return nil
+	}
+
+	probes := []string{p.key}
+	if !p.exact {
+		probes = append(probes, p.key+"/\x00")
+		if !p.segment {
+			probes = append(probes, p.key+"\x00")
+		}
+	}
+	var shadowedBy *route
+	for _, probe := range probes {
+		r, _ := h.lookup(probe)
+		if r == p.route {
+			return nil
+		}
+		if shadowedBy == nil {
+			shadowedBy = r
+		}
+	}
+	return shadowedBy
+}
+
+// radixNode is a node of a radix tree of paths, children are indexed by the
+// first byte of their prefix
+type radixNode struct {
+	prefix   string
+	indices  []byte
+	children []*radixNode
+	paths    []*pathRoute
+}
+
+func (n *radixNode) insert(key string, p *pathRoute) {
+	for {
+		common := 0
+		for common < len(key) && common < len(n.prefix) && key[common] == n.prefix[common] {
+			common++
+		}
+		if common < len(n.prefix) {
+			child := &radixNode{
+				prefix:   n.prefix[common:],
+				indices:  n.indices,
+				children: n.children,
+				paths:    n.paths,
+			}
+			n.prefix = n.prefix[:common]
+			n.indices = []byte{child.prefix[0]}
+			n.children = []*radixNode{child}
+			n.paths = nil
+		}
+
+		key = key[common:]
+		if len(key) == 0 {
+			n.paths = append(n.paths, p)
+			return
+		}
+
+		next := n.child(key[0])
+		if next == nil {
+			n.indices = append(n.indices, key[0])
+			n.children = append(n.children, &radixNode{prefix: key, paths: []*pathRoute{p}})
+			return
+		}
+		n = next
+	}
+}
+
+func (n *radixNode) child(c byte) *radixNode {
+	for i, index := range n.indices {
+		if index == c {
+			return n.children[i]
+		}
+	}
+	return nil
+}
+
+// lookup offers all paths along the request path to the match
+func (n *radixNode) lookup(requestPath string, m *routeMatch) {
+	consumed := 0
+	for {
+		consumed += len(n.prefix)
+		for _, p := range n.paths {
+			if p.matches(requestPath, consumed) {
+				m.offer(p, p.length)
+			}
+		}
+		if consumed == len(requestPath) {
+			return
+		}
+		n = n.child(requestPath[consumed])
+		if n == nil || !strings.HasPrefix(requestPath[consumed:], n.prefix) {
+			return
+		}
+	}
+}
+
+// wildcardNode is a node of the tree of wildcard hosts, a child is found by
+// the next label to the left
+type wildcardNode struct {
+	children map[string]*wildcardNode
+	routes   *hostRoutes
+}
+
+// routesFor returns the routes of a wildcard host, without the '*.'
+func (n *wildcardNode) routesFor(suffix string) *hostRoutes {
+	for len(suffix) > 0 {
+		label := suffix
+		suffix = ""
+		if dot := strings.LastIndexByte(label, '.'); dot >= 0 {
+			label, suffix = label[dot+1:], label[:dot]
+		}
+		if n.children == nil {
+			n.children = make(map[string]*wildcardNode)
+		}
+		child, ok := n.children[label]
+		if !ok {
+			child = &wildcardNode{}
+			n.children[label] = child
+		}
+		n = child
+	}
+	if n.routes == nil {
+		n.routes = newHostRoutes()
+	}
+	return n.routes
+}
+
+// lookup tries the wildcards with the longest suffix first, rest is the part
+// of the host left of the node's labels
+func (n *wildcardNode) lookup(rest, requestPath string) (*route, int) {
+	if len(rest) > 0 {
+		label, left := rest, ""
+		if dot := strings.LastIndexByte(rest, '.'); dot >= 0 {
+			label, left = rest[dot+1:], rest[:dot]
+		}
+		if child, ok := n.children[label]; ok {
+			if r, length := child.lookup(left, requestPath); r != nil {
+				return r, length
+			}
+		}
+		if n.routes != nil {
+			return n.routes.lookup(requestPath)
+		}
+	}
+	return nil, 0
+}
+
+// newRoutingTable compiles the rules of ingresses sorted by precedence, with
+// the parsed settings of every ingress
+func newRoutingTable(ingresses []*extensions.Ingress, settings map[string]*ingressSettings) *routingTable {
+	t := &routingTable{
+		hosts:     make(map[string]*hostRoutes),
+		wildcards: &wildcardNode{},
+	}
+
+	for _, ing := range ingresses {
+		s := settings[ingressKey(ing)]
+
+		if ing.Spec.Backend != nil && t.defaultBackend == nil {
+			t.defaultBackend = &route{Ingress: ing, Backend: ing.Spec.Backend, Settings: s}
+			t.all = append(t.all, t.defaultBackend)
+		}
+
+		for _, rule := range ing.Spec.Rules {
+			if rule.HTTP == nil {
+				continue
+			}
+			host := normalizeHost(rule.Host)
+
+			var routes *hostRoutes
+			switch {
+			case len(host) == 0:
+				if t.catchAll == nil {
+					t.catchAll = newHostRoutes()
+				}
+				routes = t.catchAll
+			case strings.HasPrefix(host, "*."):
+				routes = t.wildcards.routesFor(host[2:])
+			default:
+				var ok bool
+				if routes, ok = t.hosts[host]; !ok {
+					routes = newHostRoutes()
+					t.hosts[host] = routes
+				}
+			}
+			routes.addRule(t, ing, s, &rule)
+		}
+	}
+
+	return t
+}
+
+// lookup returns the route for a normalized host and a request path, and
+// the length of the matched part of the request path
+func (t *routingTable) lookup(host, requestPath string) (*route, int) {
+	if t == nil {
+		return nil, 0
+	}
+	if routes, ok := t.hosts[host]; ok {
+		if r, length := routes.lookup(requestPath); r != nil {
+			return r, length
+		}
+	}
+	if r, length := t.wildcards.lookup(host, requestPath); r != nil {
+		return r, length
+	}
+	if t.catchAll != nil {
+		if r, length := t.catchAll.lookup(requestPath); r != nil {
+			return r, length
+		}
+	}
+	return t.defaultBackend, 0
+}
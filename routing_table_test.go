@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/util/intstr"
+)
+
+// hostRankExact ranks an exact host above any wildcard
+const hostRankExact = int(^uint(0) >> 1)
+
+// hostRank rates how well a normalized rule host matches a normalized
+// request host for the reference router, it is negative if they don't match.
+// A rule without a host matches any host below every wildcard.
+func hostRank(ruleHost, host string) int {
+	if len(ruleHost) == 0 {
+		return 0
+	}
+	if ruleHost == host {
+		return hostRankExact
+	}
+	if !strings.HasPrefix(ruleHost, "*.") {
+		return -1
+	}
+	suffix := ruleHost[1:]
+	if len(host) > len(suffix) && strings.HasSuffix(host, suffix) {
+		return len(suffix)
+	}
+	return -1
+}
+
+// referenceRoute routes by comparing every rule in the order of the
+// ingresses, which the routing table has to be equivalent to. The paths of
+// all rules of a host compete, the best matching host with a matching path
+// wins.
+func referenceRoute(ingresses []*extensions.Ingress, settings map[string]*ingressSettings, host, requestPath string) (*extensions.IngressBackend, int) {
+	type match struct {
+		backend *extensions.IngressBackend
+		length  int
+		exact   bool
+	}
+	best := make(map[int]match)
+
+	for _, ing := range ingresses {
+		s := settings[ingressKey(ing)]
+		for _, rule := range ing.Spec.Rules {
+			rank := hostRank(normalizeHost(rule.Host), host)
+			if rule.HTTP == nil || rank < 0 {
+				continue
+			}
+			for pos, path := range rule.HTTP.Paths {
+				length, exact := s.matchPath(path.Path, requestPath)
+				if length == 0 {
+					continue
+				}
+				m, ok := best[rank]
+				if !ok || exact && !m.exact || exact == m.exact && length > m.length {
+					best[rank] = match{&rule.HTTP.Paths[pos].Backend, length, exact}
+				}
+			}
+		}
+	}
+
+	bestRank := -1
+	for rank := range best {
+		if rank > bestRank {
+			bestRank = rank
+		}
+	}
+	if bestRank >= 0 {
+		return best[bestRank].backend, best[bestRank].length
+	}
+
+	for _, ing := range ingresses {
+		if ing.Spec.Backend != nil {
+			return ing.Spec.Backend, 0
+		}
+	}
+	return nil, 0
+}
+
+func randomIngresses(rnd *rand.Rand, count int) []*extensions.Ingress {
+	hosts := []string{"", "www.test.de", "WWW.test.de", "*.test.de", "*.www.test.de", "api.test.de", "*.de"}
+	paths := []string{"", "/", "/api", "/api/", "/api/v1", "/apiv1", "/static", "/api/v[0-9]+", "/api/v1/users"}
+	types := []string{pathTypeExact, pathTypePrefix, pathTypeStringPrefix, pathTypeRegex}
+
+	ingresses := []*extensions.Ingress{}
+	for i := 0; i < count; i++ {
+		ing := &extensions.Ingress{
+			ObjectMeta: api.ObjectMeta{
+				Name:      fmt.Sprintf("ingress%d", i),
+				Namespace: "default",
+				Annotations: map[string]string{
+					annotationPrefix + "path-type":  types[rnd.Intn(len(types))],
+					annotationPrefix + "path-types": paths[rnd.Intn(len(paths))] + "=" + types[rnd.Intn(len(types))],
+				},
+			},
+		}
+		if rnd.Intn(4) == 0 {
+			ing.Spec.Backend = &extensions.IngressBackend{ServiceName: ing.Name, ServicePort: intstr.FromInt(80)}
+		}
+		for r := rnd.Intn(3); r >= 0; r-- {
+			rule := extensions.IngressRule{Host: hosts[rnd.Intn(len(hosts))]}
+			rule.HTTP = &extensions.HTTPIngressRuleValue{}
+			for p := rnd.Intn(4); p >= 0; p-- {
+				rule.HTTP.Paths = append(rule.HTTP.Paths, extensions.HTTPIngressPath{
+					Path: paths[rnd.Intn(len(paths))],
+					Backend: extensions.IngressBackend{
+						ServiceName: fmt.Sprintf("%s-%d-%d", ing.Name, r, p),
+						ServicePort: intstr.FromInt(8080),
+					},
+				})
+			}
+			ing.Spec.Rules = append(ing.Spec.Rules, rule)
+		}
+		ingresses = append(ingresses, ing)
+	}
+	return ingresses
+}
+
+func TestRoutingTable(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	hosts := []string{"", "www.test.de", "a.www.test.de", "b.a.www.test.de", "api.test.de", "other.de", "test.de", "example.com"}
+	paths := []string{"", "/", "/a", "/api", "/api/", "/apiv1", "/api/v1", "/api/v12/users", "/api/v1/users/42", "/static/app.js"}
+
+	for round := 0; round < 200; round++ {
+		ip := NewIngressProxy()
+		ip.SetIngresses(randomIngresses(rnd, 1+rnd.Intn(5)))
+
+		snap := ip.currentSnapshot()
+		for _, host := range hosts {
+			for _, path := range paths {
+				backend, length := referenceRoute(snap.ingresses, snap.settings, host, path)
+				r, matched := snap.routes.lookup(host, path)
+				if r == nil && backend != nil || r != nil && (r.Backend != backend || matched != length) {
+					t.Fatalf("round %d: host=%s path=%s routed to %+v matching %d, expected %+v matching %d", round, host, path, r, matched, backend, length)
+				}
+			}
+		}
+	}
+}
+
+func TestRoutingTableMergesIngresses(t *testing.T) {
+	older := &extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{Name: "older", Namespace: "default", CreationTimestamp: unversioned.NewTime(time.Unix(1000, 0))},
+		Spec:       extensions.IngressSpec{Rules: []extensions.IngressRule{exampleRule("www.test.de", "root")}},
+	}
+	newerRule := exampleRule("www.test.de", "api")
+	newerRule.HTTP.Paths[0].Path = "/api"
+	newer := &extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{Name: "newer", Namespace: "default", CreationTimestamp: unversioned.NewTime(time.Unix(2000, 0))},
+		Spec:       extensions.IngressSpec{Rules: []extensions.IngressRule{newerRule}},
+	}
+
+	ip := NewIngressProxy()
+	ip.SetIngresses([]*extensions.Ingress{newer, older})
+	for path, service := range map[string]string{"/": "root", "/static": "root", "/api": "api", "/api/x": "api"} {
+		r := &http.Request{Host: "www.test.de", URL: &url.URL{Path: path}}
+		if b := ip.routeRequestToBackend(r); b == nil || b.ServiceName != service {
+			t.Errorf("path %s routed to %+v, expected service %s", path, b, service)
+		}
+	}
+}
+
+func TestRoutingTableCatchAllRule(t *testing.T) {
+	catchAll := &extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{Name: "catch-all", Namespace: "default"},
+		Spec:       extensions.IngressSpec{Rules: []extensions.IngressRule{exampleRule("", "any")}},
+	}
+	wildcard := &extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{Name: "wildcard", Namespace: "default"},
+		Spec:       extensions.IngressSpec{Rules: []extensions.IngressRule{exampleRule("*.api.test.de", "api")}},
+	}
+
+	ip := NewIngressProxy()
+	ip.SetIngresses([]*extensions.Ingress{catchAll, wildcard})
+	for host, service := range map[string]string{"www.test.de": "any", "www.test.de:8080": "any", "": "any", "v1.api.test.de": "api"} {
+		r := &http.Request{Host: host, URL: &url.URL{Path: "/index.html"}}
+		if b := ip.routeRequestToBackend(r); b == nil || b.ServiceName != service {
+			t.Errorf("host %s routed to %+v, expected service %s", host, b, service)
+		}
+	}
+}
+
+func TestRoutingTableAllocations(t *testing.T) {
+	ip := NewIngressProxy()
+	ip.SetIngresses(benchmarkIngresses(100, 10))
+
+	routes := ip.currentSnapshot().routes
+	r := &http.Request{Host: "host50.example.com:8080", URL: &url.URL{Path: "/path5/foo"}}
+	allocs := testing.AllocsPerRun(100, func() {
+		routes.lookup(normalizeHost(r.Host), r.URL.Path)
+	})
+	if allocs != 0 {
+		t.Errorf("lookup allocated %f times", allocs)
+	}
+}
+
+// benchmarkIngresses creates an ingress per host with a number of paths and
+// a wildcard host for every tenth host
+func benchmarkIngresses(hosts, paths int) []*extensions.Ingress {
+	ingresses := []*extensions.Ingress{}
+	for h := 0; h < hosts; h++ {
+		host := fmt.Sprintf("host%d.example.com", h)
+		if h%10 == 0 {
+			host = fmt.Sprintf("*.wildcard%d.example.com", h)
+		}
+		rule := exampleRule(host, "service")
+		for p := 0; p < paths; p++ {
+			rule.HTTP.Paths = append(rule.HTTP.Paths, extensions.HTTPIngressPath{
+				Path: fmt.Sprintf("/path%d", p),
+				Backend: extensions.IngressBackend{
+					ServiceName: fmt.Sprintf("service%d", p),
+					ServicePort: intstr.FromInt(8080),
+				},
+			})
+		}
+		ingresses = append(ingresses, &extensions.Ingress{
+			ObjectMeta: api.ObjectMeta{Name: fmt.Sprintf("ingress%d", h), Namespace: "default"},
+			Spec:       extensions.IngressSpec{Rules: []extensions.IngressRule{rule}},
+		})
+	}
+	return ingresses
+}
+
+func BenchmarkRoutingTable(b *testing.B) {
+	for _, size := range []struct {
+		hosts int
+		paths int
+	}{
+		{10, 10},
+		{100, 10},
+		{1000, 10},
+		{10000, 10},
+		{1000, 100},
+	} {
+		ip := NewIngressProxy()
+		ip.SetIngresses(benchmarkIngresses(size.hosts, size.paths))
+		routes := ip.currentSnapshot().routes
+
+		for _, request := range []struct {
+			name string
+			host string
+		}{
+			{"exact", fmt.Sprintf("host%d.example.com", size.hosts-1)},
+			{"wildcard", "foo.wildcard0.example.com"},
+			{"miss", "unknown.example.com"},
+		} {
+			requestPath := fmt.Sprintf("/path%d/foo", size.paths-1)
+			b.Run(fmt.Sprintf("hosts=%d/paths=%d/%s", size.hosts, size.paths, request.name), func(b *testing.B) {
+				b.ReportAllocs()
+				for n := 0; n < b.N; n++ {
+					routes.lookup(normalizeHost(request.host), requestPath)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// The servers keep listening with the settings they are started with, but
+// their timeouts and header limit follow the current config so the ConfigMap
+// can change them. The servers themselves have no timeouts, connections get
+// the deadlines of the current config whenever a request starts being read
+// or handled. Headers are read up to a fixed limit and checked against the
+// limit of the current config when handling the request.
+
+// maxHeaderBytesLimit is the most the servers read of request headers
+const maxHeaderBytesLimit = 8 << 20
+
+// timeoutListener tracks the connections of a server to set their deadlines
+type timeoutListener struct {
+	net.Listener
+	ip        *IngressProxy
+	tlsConfig *tls.Config
+
+	lock  sync.Mutex
+	conns map[net.Conn]*timeoutConn
+}
+
+func newTimeoutListener(ip *IngressProxy, port int, tlsConfig *tls.Config) (*timeoutListener, error) {
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, err
+	}
+	return &timeoutListener{
+		Listener:  l,
+		ip:        ip,
+		tlsConfig: tlsConfig,
+		conns:     make(map[net.Conn]*timeoutConn),
+	}, nil
+}
+
+// Accept wraps connections, TLS is handled on top of the wrapped connection
+// so the server still sees a TLS connection
+func (l *timeoutListener) Accept() (net.Conn, error) {
+	raw, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	tc := &timeoutConn{Conn: raw}
+	var conn net.Conn = tc
+	if l.tlsConfig != nil {
+		conn = tls.Server(tc, l.tlsConfig)
+	}
+
+	l.lock.Lock()
+	l.conns[conn] = tc
+	l.lock.Unlock()
+	return conn, nil
+}
+
+// connState sets the deadlines of a connection from the current config
+// whenever its state changes. A new or idle connection has to send the
+// headers of the next request within the read timeout. Once they are read,
+// the body has to be read within the read timeout and the response written
+// within the write timeout.
+func (l *timeoutListener) connState(conn net.Conn, state http.ConnState) {
+	l.lock.Lock()
+	tc := l.conns[conn]
+	if state == http.StateClosed || state == http.StateHijacked {
+		delete(l.conns, conn)
+	}
+	l.lock.Unlock()
+	if tc == nil {
+		return
+	}
+
+	c := l.ip.currentConfig()
+	now := time.Now()
+	switch state {
+	case http.StateNew:
+		tc.setDeadlines(deadline(now, c.ReadTimeout), deadline(now, c.WriteTimeout))
+	case http.StateIdle:
+		tc.setDeadlines(deadline(now, c.ReadTimeout), time.Time{})
+	case http.StateActive:
+		tc.setDeadlines(deadline(now, c.ReadTimeout), deadline(now, c.WriteTimeout))
+	case http.StateHijacked:
+		tc.setDeadlines(time.Time{}, time.Time{})
+	}
+}
+
+// deadline returns the deadline for a timeout, none if it is 0
+func deadline(now time.Time, timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return now.Add(timeout)
+}
+
+// timeoutConn is a connection with deadlines set from the config. The
+// server clears the deadlines of a connection as it has no timeouts, the
+// deadlines of the config are kept in place instead.
+type timeoutConn struct {
+	net.Conn
+
+	lock          sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func (c *timeoutConn) setDeadlines(read, write time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.readDeadline = read
+	c.writeDeadline = write
+	c.Conn.SetReadDeadline(read)
+	c.Conn.SetWriteDeadline(write)
+}
+
+func (c *timeoutConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+func (c *timeoutConn) SetReadDeadline(t time.Time) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if t.IsZero() {
+		t = c.readDeadline
+	}
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *timeoutConn) SetWriteDeadline(t time.Time) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if t.IsZero() {
+		t = c.writeDeadline
+	}
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// headerBytes returns the size of the request line and headers of a request
+// as sent by the client
+func headerBytes(r *http.Request) int {
+	size := len(r.Method) + len(r.RequestURI) + len(r.Proto) + len(r.Host) + 4
+	for name, values := range r.Header {
+		for _, value := range values {
+			size += len(name) + len(value) + 4
+		}
+	}
+	return size
+}
+
+// server creates a server for a listener, its connections follow the
+// timeouts of the current config
+func (ip *IngressProxy) server(l *timeoutListener) *http.Server {
+	return &http.Server{
+		MaxHeaderBytes: maxHeaderBytesLimit,
+		ConnState:      l.connState,
+	}
+}
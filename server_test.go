@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+func TestReloadAppliesHeaderLimit(t *testing.T) {
+	ip := exampleIngress()
+	request := func() int {
+		r := httptest.NewRequest("GET", "http://www.test.de/", nil)
+		r.Header.Set("X-Large", strings.Repeat("x", 2048))
+		w := httptest.NewRecorder()
+		ip.handle(w, r)
+		return w.Code
+	}
+
+	if code := request(); code == 431 {
+		t.Errorf("request within the default header limit rejected")
+	}
+	reconfigure(t, ip, func(c *Config) {
+		c.MaxHeaderBytes = 1024
+	})
+	if code := request(); code != 431 {
+		t.Errorf("expected status 431 for headers above the reloaded limit, got %d", code)
+	}
+
+	if _, err := NewConfig().withConfigMap(map[string]string{"max-header-bytes": fmt.Sprint(maxHeaderBytesLimit + 1)}); err == nil {
+		t.Errorf("expected an error for a header limit above what the servers read")
+	}
+}
+
+func TestReloadAppliesTimeouts(t *testing.T) {
+	ip := exampleIngress()
+	l, err := newTimeoutListener(ip, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := ip.server(l)
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	go server.Serve(l)
+	defer l.Close()
+
+	// waitClosed checks if the server closes an idle connection within a
+	// duration
+	waitClosed := func(conn net.Conn, within time.Duration) bool {
+		conn.SetReadDeadline(time.Now().Add(within))
+		_, err := conn.Read(make([]byte, 1))
+		netErr, ok := err.(net.Error)
+		return err != nil && !(ok && netErr.Timeout())
+	}
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if waitClosed(conn, 200*time.Millisecond) {
+		t.Fatalf("connection closed before the default read timeout")
+	}
+
+	reconfigure(t, ip, func(c *Config) {
+		c.ReadTimeout = 100 * time.Millisecond
+	})
+	conn, err = net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: www.test.de\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if !waitClosed(conn, time.Second) {
+		t.Errorf("idle connection not closed after the reloaded read timeout")
+	}
+}
+
+func TestReloadAppliesLogSettings(t *testing.T) {
+	ip := exampleIngress()
+	defer NewConfig().setupLogging()
+
+	reconfigure(t, ip, func(c *Config) {
+		c.LogLevel = "debug"
+		c.LogFormat = "json"
+	})
+	s := logFormatter.settings.Load().(*logSettings)
+	if _, ok := s.formatter.(*log.JSONFormatter); !ok || s.level != log.DebugLevel {
+		t.Errorf("log settings not applied, got %+v", s)
+	}
+
+	reconfigure(t, ip, func(c *Config) {
+		c.LogLevel = "error"
+	})
+	out, err := logFormatter.Format(&log.Entry{Level: log.WarnLevel, Data: log.Fields{}})
+	if err != nil || len(out) > 0 {
+		t.Errorf("entry above the log level formatted to '%s'", out)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/util/intstr"
+)
+
+// Backends refer to a port of their service by number or by name. Named
+// ports are resolved through the service, the port to dial endpoints on is
+// the target port of the service port, as listed in the endpoints.
+
+// findServicePort returns the port of a service a backend port refers to
+func findServicePort(service *api.Service, port intstr.IntOrString) (*api.ServicePort, bool) {
+	for i := range service.Spec.Ports {
+		servicePort := &service.Spec.Ports[i]
+		if port.Type == intstr.Int && servicePort.Port == port.IntValue() {
+			return servicePort, true
+		}
+		if port.Type == intstr.String && servicePort.Name == port.StrVal {
+			return servicePort, true
+		}
+	}
+	return nil, false
+}
+
+// serviceHasPort checks if a backend port refers to a port of the service,
+// either by number or by name
+func serviceHasPort(service *api.Service, port intstr.IntOrString) bool {
+	_, ok := findServicePort(service, port)
+	return ok
+}
+
+// lookupServicePort returns the service port of a backend
+func (ip *IngressProxy) lookupServicePort(namespace string, b *extensions.IngressBackend) (*api.ServicePort, error) {
+	if ip.kubeClient == nil && ip.serviceCache == nil {
+		return nil, fmt.Errorf("port '%s' of service '%s/%s' can't be resolved without API server", b.ServicePort.String(), namespace, b.ServiceName)
+	}
+	service, err := ip.getService(namespace, b.ServiceName)
+	if err != nil {
+		return nil, fmt.Errorf("service '%s/%s' not found: %s", namespace, b.ServiceName, err)
+	}
+	servicePort, ok := findServicePort(service, b.ServicePort)
+	if !ok {
+		return nil, fmt.Errorf("service '%s/%s' has no port '%s'", namespace, b.ServiceName, b.ServicePort.String())
+	}
+	return servicePort, nil
+}
+
+// resolveServicePort returns the number of the service port of a backend
+func (ip *IngressProxy) resolveServicePort(namespace string, b *extensions.IngressBackend) (int, error) {
+	if b.ServicePort.Type == intstr.Int {
+		return b.ServicePort.IntValue(), nil
+	}
+	servicePort, err := ip.lookupServicePort(namespace, b)
+	if err != nil {
+		return 0, err
+	}
+	return servicePort.Port, nil
+}
+
+// endpointPort returns the port endpoints of a subset listen on for a
+// service port, endpoint ports are named like the service ports
+func endpointPort(servicePort *api.ServicePort, subset *api.EndpointSubset) (int, bool) {
+	for _, port := range subset.Ports {
+		if port.Name == servicePort.Name {
+			return port.Port, true
+		}
+	}
+	if servicePort.TargetPort.Type == intstr.Int && servicePort.TargetPort.IntValue() > 0 {
+		return servicePort.TargetPort.IntValue(), true
+	}
+	return 0, false
+}
+
+// endpointAddresses returns the addresses to dial the ready endpoints of a
+// backend on, with the target port of its service port
+func (ip *IngressProxy) endpointAddresses(namespace string, b *extensions.IngressBackend) ([]endpointAddress, error) {
+	servicePort, err := ip.lookupServicePort(namespace, b)
+	if err != nil {
+		return nil, err
+	}
+	endpoints, err := ip.getEndpoints(namespace, b.ServiceName)
+	if err != nil {
+		return nil, fmt.Errorf("endpoints '%s/%s' not found: %s", namespace, b.ServiceName, err)
+	}
+
+	addresses := []endpointAddress{}
+	for i := range endpoints.Subsets {
+		subset := &endpoints.Subsets[i]
+		port, ok := endpointPort(servicePort, subset)
+		if !ok {
+			return nil, fmt.Errorf("target port of port '%s' of service '%s/%s' not found in its endpoints", b.ServicePort.String(), namespace, b.ServiceName)
+		}
+		for _, address := range subset.Addresses {
+			a := endpointAddress{
+				Address: net.JoinHostPort(address.IP, strconv.Itoa(port)),
+				IP:      address.IP,
+			}
+			if address.TargetRef != nil && address.TargetRef.Kind == "Pod" {
+				a.Pod = address.TargetRef.Name
+			}
+			addresses = append(addresses, a)
+		}
+	}
+	return addresses, nil
+}
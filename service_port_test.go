@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/util/intstr"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+// syncedCache returns a cache holding a fixed list of objects
+func syncedCache(t *testing.T, kind string, objs ...runtime.Object) *resourceCache {
+	c := newResourceCache(kind, []string{api.NamespaceAll},
+		resourceSource{
+			func(namespace string, opts api.ListOptions) ([]runtime.Object, string, error) {
+				return objs, "1", nil
+			},
+			func(namespace string, opts api.ListOptions) (watch.Interface, error) {
+				return watch.NewFake(), nil
+			},
+		},
+		onAnyChange(func() {}),
+	)
+	if err := c.sync(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func exampleServicePorts(t *testing.T) *IngressProxy {
+	service := exampleService("default", "web", "1")
+	service.Spec.Ports = []api.ServicePort{
+		{Name: "http", Port: 80, TargetPort: intstr.FromString("web")},
+		{Name: "metrics", Port: 9090, TargetPort: intstr.FromInt(9091)},
+	}
+	endpoints := &api.Endpoints{
+		ObjectMeta: api.ObjectMeta{Name: "web", Namespace: "default"},
+		Subsets: []api.EndpointSubset{{
+			Addresses: []api.EndpointAddress{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}},
+			Ports:     []api.EndpointPort{{Name: "http", Port: 8080}, {Name: "metrics", Port: 9091}},
+		}},
+	}
+
+	ip := NewIngressProxy()
+	ip.serviceCache = syncedCache(t, "services", service)
+	ip.endpointsCache = syncedCache(t, "endpoints", endpoints)
+	ip.secretCache = syncedCache(t, "secrets")
+	return ip
+}
+
+func TestResolveServicePort(t *testing.T) {
+	ip := exampleServicePorts(t)
+
+	for _, test := range []struct {
+		port      intstr.IntOrString
+		url       string
+		addresses []string
+	}{
+		{intstr.FromString("http"), "http://web.default.svc.cluster.local:80", []string{"10.0.0.1:8080", "10.0.0.2:8080"}},
+		{intstr.FromInt(80), "http://web.default.svc.cluster.local:80", []string{"10.0.0.1:8080", "10.0.0.2:8080"}},
+		{intstr.FromString("metrics"), "http://web.default.svc.cluster.local:9090", []string{"10.0.0.1:9091", "10.0.0.2:9091"}},
+	} {
+		b := &ingressBackend{
+			IngressBackend: &extensions.IngressBackend{ServiceName: "web", ServicePort: test.port},
+			Namespace:      "default",
+		}
+		if u, err := ip.urlFromBackend(ip.currentConfig(), b); err != nil || u.String() != test.url {
+			t.Errorf("port '%s' resolved to url=%s err=%v, expected %s", test.port.String(), u, err, test.url)
+		}
+		endpoints, err := ip.endpointAddresses("default", b.IngressBackend)
+		addresses := []string{}
+		for _, e := range endpoints {
+			addresses = append(addresses, e.Address)
+		}
+		if err != nil || !reflect.DeepEqual(addresses, test.addresses) {
+			t.Errorf("port '%s' resolved to endpoints=%v err=%v, expected %v", test.port.String(), addresses, err, test.addresses)
+		}
+	}
+
+	for _, backend := range []*extensions.IngressBackend{
+		{ServiceName: "web", ServicePort: intstr.FromString("grpc")},
+		{ServiceName: "web", ServicePort: intstr.FromInt(443)},
+		{ServiceName: "unknown", ServicePort: intstr.FromString("http")},
+	} {
+		if addresses, err := ip.endpointAddresses("default", backend); err == nil {
+			t.Errorf("port '%s' of service %s resolved to endpoints=%v, expected an error", backend.ServicePort.String(), backend.ServiceName, addresses)
+		}
+	}
+}
+
+func TestUnresolvedServicePort(t *testing.T) {
+	ip := exampleServicePorts(t)
+
+	rule := exampleRule("www.test.de", "web")
+	rule.HTTP.Paths[0].Backend.ServicePort = intstr.FromString("grpc")
+	ing := &extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{Name: "ingress1", Namespace: "default"},
+		Spec: extensions.IngressSpec{
+			Rules: []extensions.IngressRule{rule},
+		},
+	}
+	ip.SetIngresses([]*extensions.Ingress{ing})
+
+	w := httptest.NewRecorder()
+	ip.handle(w, httptest.NewRequest("GET", "http://www.test.de/", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("request to an unresolved port got status %d, expected %d", w.Code, http.StatusServiceUnavailable)
+	}
+
+	// without API server named ports are only accepted with an override
+	ip = NewIngressProxy()
+	if problems := ip.validateIngress(ing); len(problems) != 1 || problems[0].Reason != reasonUnknownPort {
+		t.Errorf("expected an unknown port, got %v", problems)
+	}
+	reconfigure(t, ip, func(c *Config) {
+		c.BackendOverrides = []string{"web:grpc=127.0.0.1:3000"}
+	})
+	if problems := ip.validateIngress(ing); len(problems) != 0 {
+		t.Errorf("expected no problems with an override, got %v", problems)
+	}
+}
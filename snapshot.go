@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// Everything needed to handle requests is bundled into a snapshot, which is
+// never changed once built. A new snapshot is built and swapped in whenever
+// ingresses, secrets or the config change, requests keep using the snapshot
+// they started with.
+
+// snapshot is the state requests are handled with
+type snapshot struct {
+	config    *runtimeConfig
+	ingresses []*extensions.Ingress
+	settings  map[string]*ingressSettings
+	routes    *routingTable
+	proxies   map[string]*backendProxy
+
+	// certificates are the certificates of the TLS secrets by host, the
+	// first certificate is served to clients asking for other hosts
+	certificates       map[string]*tls.Certificate
+	defaultCertificate *tls.Certificate
+
+	// secrets are the loaded TLS secrets by namespace/name, their
+	// certificates are reused while the secrets don't change
+	secrets map[string]*tlsSecret
+
+	// references are the services and secrets the ingresses refer to, only
+	// changes of these need a rebuild
+	references objectReferences
+
+	// tlsConfig serves the certificates with the TLS settings of the config
+	tlsConfig *tls.Config
+
+	// authSecrets are the credentials of the auth secrets by namespace/name
+	authSecrets map[string]*authSecret
+}
+
+// currentSnapshot returns the snapshot to handle a request with
+func (ip *IngressProxy) currentSnapshot() *snapshot {
+	return ip.snapshot.Load().(*snapshot)
+}
+
+// ingresses returns the ingresses currently used for routing
+func (ip *IngressProxy) ingresses() []*extensions.Ingress {
+	return ip.currentSnapshot().ingresses
+}
+
+// updateSnapshot builds a snapshot from the current config and ingresses
+// sorted by precedence and swaps it in
+func (ip *IngressProxy) updateSnapshot(ingresses []*extensions.Ingress) *snapshot {
+	ip.snapshotLock.Lock()
+	defer ip.snapshotLock.Unlock()
+
+	previous, _ := ip.snapshot.Load().(*snapshot)
+
+	s := &snapshot{
+		config:    ip.currentConfig(),
+		ingresses: ingresses,
+	}
+	s.settings = parseSettings(ingresses, s.config.Config)
+	s.references = objectReferences{}
+	for _, ing := range ingresses {
+		s.references.addIngress(ing)
+	}
+	s.routes = newRoutingTable(ingresses, s.settings)
+	ip.buildBackendProxies(s, previous)
+	ip.loadCertificates(s, previous)
+	ip.loadAuthSecrets(s, previous)
+	s.tlsConfig = s.config.tls.Clone()
+	s.tlsConfig.GetCertificate = s.getCertificate
+
+	ip.snapshot.Store(s)
+	closeStaleBackendProxies(s, previous)
+	return s
+}
+
+// routeRequestToBackend looks up the backend for a request in the merged rules
+// of all ingresses. The rule with the best matching host wins, for equally
+// matching hosts ingresses are consulted in order of their precedence. The
+// first default backend found is used if no rule matches.
+func (s *snapshot) routeRequestToBackend(r *http.Request) *ingressBackend {
+	route, matched := s.routes.lookup(normalizeHost(r.Host), r.URL.Path)
+	if route == nil {
+		return nil
+	}
+	return route.ingressBackend(r.URL.Path[:matched])
+}
+
+// loadCertificates loads the certificates of the TLS secrets for their
+// hosts, the ingress with the highest precedence wins a host. The
+// certificate of the previous snapshot is kept for the hosts of a secret
+// which is missing or invalid.
+func (ip *IngressProxy) loadCertificates(s, previous *snapshot) {
+	s.certificates = make(map[string]*tls.Certificate)
+	s.secrets = make(map[string]*tlsSecret)
+	if ip.kubeClient == nil && ip.secretCache == nil {
+		return
+	}
+
+	loaded := make(map[string]*tls.Certificate)
+	failed := false
+	for _, ing := range s.ingresses {
+		for _, t := range ing.Spec.TLS {
+			if len(t.SecretName) == 0 {
+				continue
+			}
+			key := ing.Namespace + "/" + t.SecretName
+			cert, ok := loaded[key]
+			if !ok {
+				if secret, err := ip.loadTLSSecret(ing, t.SecretName, previous); err == nil {
+					s.secrets[key] = secret
+					cert = secret.certificate
+				} else {
+					failed = true
+				}
+				loaded[key] = cert
+			}
+
+			for _, host := range t.Hosts {
+				host = normalizeHost(host)
+				if _, ok := s.certificates[host]; ok {
+					continue
+				}
+				if cert != nil {
+					s.certificates[host] = cert
+				} else if old := previous.loadedCertificate(host); old != nil {
+					log.Warnf("Keeping the previous TLS certificate for host %s", host)
+					s.certificates[host] = old
+				}
+			}
+			if s.defaultCertificate == nil {
+				s.defaultCertificate = cert
+			}
+		}
+	}
+
+	if s.defaultCertificate == nil && failed && previous != nil {
+		s.defaultCertificate = previous.defaultCertificate
+	}
+}
+
+// tlsSecret is the certificate loaded from a version of a TLS secret
+type tlsSecret struct {
+	resourceVersion string
+	certificate     *tls.Certificate
+}
+
+// loadTLSSecret reads the certificate of a TLS secret, the certificate of the
+// previous snapshot is reused if the secret didn't change. Problems are
+// reported as events.
+func (ip *IngressProxy) loadTLSSecret(ing *extensions.Ingress, secretName string, previous *snapshot) (*tlsSecret, error) {
+	secret, err := ip.getSecret(ing.Namespace, secretName)
+	if err != nil {
+		ip.recordEvent(ing, api.EventTypeWarning, reasonMissingSecret, "TLS secret '%s/%s' not found: %s", ing.Namespace, secretName, err)
+		return nil, err
+	}
+
+	if previous != nil && len(secret.ResourceVersion) > 0 {
+		if loaded, ok := previous.secrets[ing.Namespace+"/"+secretName]; ok && loaded.resourceVersion == secret.ResourceVersion {
+			return loaded, nil
+		}
+	}
+
+	cert, err := tls.X509KeyPair(secret.Data[api.TLSCertKey], secret.Data[api.TLSPrivateKeyKey])
+	if err != nil {
+		ip.recordEvent(ing, api.EventTypeWarning, reasonInvalidSecret, "TLS secret '%s/%s' is invalid: %s", ing.Namespace, secretName, err)
+		return nil, err
+	}
+	return &tlsSecret{resourceVersion: secret.ResourceVersion, certificate: &cert}, nil
+}
+
+// loadedCertificate returns the certificate loaded for a host of a snapshot
+func (s *snapshot) loadedCertificate(host string) *tls.Certificate {
+	if s == nil {
+		return nil
+	}
+	return s.certificates[host]
+}
+
+// certificate returns the certificate for the host a client asked for, a
+// wildcard host covers a single label
+func (s *snapshot) certificate(serverName string) *tls.Certificate {
+	host := normalizeHost(serverName)
+	if cert, ok := s.certificates[host]; ok {
+		return cert
+	}
+	if dot := strings.IndexByte(host, '.'); dot >= 0 {
+		if cert, ok := s.certificates["*"+host[dot:]]; ok {
+			return cert
+		}
+	}
+	return s.defaultCertificate
+}
+
+func (s *snapshot) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cert := s.certificate(hello.ServerName); cert != nil {
+		return cert, nil
+	}
+	return nil, fmt.Errorf("no TLS certificate for '%s'", hello.ServerName)
+}
+
+// getConfigForClient serves TLS connections with the certificates and TLS
+// settings of the current snapshot
+func (ip *IngressProxy) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return ip.currentSnapshot().tlsConfig, nil
+}
+
+// getCertificate picks the certificate from the current snapshot
+func (ip *IngressProxy) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return ip.currentSnapshot().getCertificate(hello)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+func TestSnapshotReloads(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "path=%s", r.URL.Path)
+	}))
+	defer backend.Close()
+	backendURL, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := NewConfig()
+	config.BackendOverrides = []string{"service1:8080=" + backendURL.Host}
+
+	ip := NewIngressProxy()
+	if err := ip.reloadConfig(config); err != nil {
+		t.Fatal(err)
+	}
+
+	ingress := func(version int) *extensions.Ingress {
+		rule := exampleRule("www.test.de", "service1")
+		rule.HTTP.Paths[0].Path = fmt.Sprintf("/v%d", version%2)
+		return &extensions.Ingress{
+			ObjectMeta: api.ObjectMeta{
+				Name:            "ingress1",
+				Namespace:       "default",
+				ResourceVersion: fmt.Sprintf("%d", version),
+				Annotations:     map[string]string{annotationPrefix + "rewrite-target": "/"},
+			},
+			Spec: extensions.IngressSpec{
+				Rules: []extensions.IngressRule{rule},
+			},
+		}
+	}
+	ip.storeIngress(ingress(0))
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+
+	// reload ingresses and config while requests are handled
+	go func() {
+		defer close(stopped)
+		for version := 1; ; version++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			ip.storeIngress(ingress(version))
+			changed := *config
+			changed.UpstreamTimeout = time.Duration(30+version%2) * time.Second
+			changed.IngressClass = []string{"", "kube-ingress-proxy"}[version%2]
+			changed.ExcludedNamespaces = []string{fmt.Sprintf("team-%d", version%2)}
+			if err := ip.reloadConfig(&changed); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	// the watchers, ingress checks and status sync use the config while it
+	// is reloaded
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for n := 0; n < 200; n++ {
+			if claimed, reason := ip.claimsIngress(ingress(n)); !claimed {
+				t.Errorf("ingress without class not claimed: %s", reason)
+			}
+			if problems := ip.validateIngress(ingress(n)); len(problems) != 0 {
+				t.Errorf("unexpected problems %v", problems)
+			}
+			if !ip.namespaceAllowed("default") {
+				t.Error("namespace default not allowed")
+			}
+			if err := ip.ingressListOptions(api.NamespaceAll, &api.ListOptions{}); err != nil {
+				t.Error(err)
+			}
+			ip.publishesStatus()
+		}
+	}()
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 50; n++ {
+				for _, path := range []string{"/v0/foo", "/v1/foo"} {
+					r := httptest.NewRequest("GET", "http://www.test.de"+path, nil)
+					w := httptest.NewRecorder()
+					ip.handle(w, r)
+					// only one of the paths is routed at a time
+					if w.Code == http.StatusOK && !strings.HasSuffix(w.Body.String(), "path=/foo") {
+						t.Errorf("request for %s got unexpected response %q", path, w.Body.String())
+					}
+					if w.Code != http.StatusOK && w.Code != http.StatusServiceUnavailable {
+						t.Errorf("request for %s got unexpected status %d", path, w.Code)
+					}
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(stop)
+	<-stopped
+}
+
+// exampleTLSSecret creates a secret with a self-signed certificate for a host
+func exampleTLSSecret(t *testing.T, name, host string) *api.Secret {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &api.Secret{
+		ObjectMeta: api.ObjectMeta{Name: name, Namespace: "default"},
+		Data: map[string][]byte{
+			api.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}),
+			api.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}),
+		},
+	}
+}
+
+// servedCertificate returns the common name of the certificate served for a
+// server name, or an empty string if the handshake fails
+func servedCertificate(ip *IngressProxy, serverName string) string {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go tls.Server(server, &tls.Config{
+		GetConfigForClient: ip.getConfigForClient,
+		GetCertificate:     ip.getCertificate,
+	}).Handshake()
+
+	conn := tls.Client(client, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	if err := conn.Handshake(); err != nil {
+		return ""
+	}
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+func TestSnapshotCertificates(t *testing.T) {
+	ip := NewIngressProxy()
+	ip.secretCache = syncedCache(t, "secrets")
+	if cn := servedCertificate(ip, "www.test.de"); cn != "" {
+		t.Errorf("certificate %s served without TLS secrets", cn)
+	}
+
+	ingress := func(name string, created int64, tls ...extensions.IngressTLS) *extensions.Ingress {
+		return &extensions.Ingress{
+			ObjectMeta: api.ObjectMeta{Name: name, Namespace: "default", CreationTimestamp: unversioned.NewTime(time.Unix(created, 0))},
+			Spec:       extensions.IngressSpec{TLS: tls},
+		}
+	}
+	ingresses := []*extensions.Ingress{
+		ingress("ingress1", 1000, extensions.IngressTLS{Hosts: []string{"www.test.de"}, SecretName: "test"}),
+		ingress("ingress2", 2000,
+			extensions.IngressTLS{Hosts: []string{"*.example.com"}, SecretName: "example"},
+			extensions.IngressTLS{Hosts: []string{"www.test.de"}, SecretName: "other"},
+		),
+	}
+
+	// secrets added after the start are served by host
+	ip.secretCache = syncedCache(t, "secrets",
+		exampleTLSSecret(t, "test", "www.test.de"),
+		exampleTLSSecret(t, "example", "*.example.com"),
+		exampleTLSSecret(t, "other", "other"),
+	)
+	ip.SetIngresses(ingresses)
+	for serverName, expected := range map[string]string{
+		"www.test.de":        "www.test.de",
+		"WWW.Test.de":        "www.test.de",
+		"shop.example.com":   "*.example.com",
+		"a.shop.example.com": "www.test.de",
+		"www.unknown.de":     "www.test.de",
+		"":                   "www.test.de",
+	} {
+		if cn := servedCertificate(ip, serverName); cn != expected {
+			t.Errorf("server name '%s' got certificate %s, expected %s", serverName, cn, expected)
+		}
+	}
+
+	// certificates are only parsed again once their secret changes
+	secret := exampleTLSSecret(t, "test", "www.test.de")
+	secret.ResourceVersion = "1"
+	ip.secretCache = syncedCache(t, "secrets", secret)
+	ip.SetIngresses(ingresses)
+	loaded := ip.currentSnapshot().certificates["www.test.de"]
+	ip.SetIngresses(ingresses)
+	if cert := ip.currentSnapshot().certificates["www.test.de"]; cert != loaded {
+		t.Errorf("certificate of an unchanged secret parsed again")
+	}
+	updated := *secret
+	updated.ResourceVersion = "2"
+	ip.secretCache = syncedCache(t, "secrets", &updated)
+	ip.SetIngresses(ingresses)
+	if cert := ip.currentSnapshot().certificates["www.test.de"]; cert == loaded {
+		t.Errorf("certificate of a changed secret not parsed again")
+	}
+
+	// the previous certificate is kept while a secret is missing
+	ip.secretCache = syncedCache(t, "secrets", exampleTLSSecret(t, "other", "other"))
+	ip.SetIngresses(ingresses)
+	if cn := servedCertificate(ip, "www.test.de"); cn != "www.test.de" {
+		t.Errorf("previous certificate not kept, got %s", cn)
+	}
+}